@@ -0,0 +1,350 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// healthTimeout bounds the renderer health probe of the admin API.
+const healthTimeout = 5 * time.Second
+
+// AdminHandler returns an http.Handler exposing a JSON admin API for runtime
+// inspection and cache management. It is meant to be mounted under a prefix
+// with http.StripPrefix and must be protected by the app as it allows
+// purging the cache.
+//
+//	GET    /config               the effective configuration (secrets redacted)
+//	GET    /stats                the render load and number of cached pages,
+//	                             with those of each ForHost host under "hosts"
+//	GET    /cache?prefix=...     the cache keys starting with prefix
+//	DELETE /cache?url=...        purge a URL (may be repeated)
+//	DELETE /cache?prefix=...     purge all URLs starting with prefix
+//	DELETE /cache?all=1          purge the entire cache
+//	GET    /snapshots?url=...    the cache state of a URL (may be repeated)
+//	GET    /health               probe the prerender service, unless a
+//	                             RenderBackend is set
+func (h *Prerenderer) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var (
+			v   interface{}
+			err error
+		)
+
+		switch route := strings.Trim(req.URL.Path, "/"); {
+		case route == "config" && req.Method == "GET":
+			v = h.adminConfig()
+		case route == "stats" && req.Method == "GET":
+			v, err = h.adminStats(req.Context())
+		case route == "cache" && req.Method == "GET":
+			v, err = h.adminKeys(req.Context(), req.URL.Query().Get("prefix"))
+		case route == "cache" && req.Method == "DELETE":
+			query := req.URL.Query()
+			if len(query["url"]) == 0 && len(query["prefix"]) == 0 && query.Get("all") != "1" {
+				http.Error(rw, "Missing url, prefix or all=1", http.StatusBadRequest)
+				return
+			}
+			v, err = h.adminPurge(req.Context(), query)
+		case route == "snapshots" && req.Method == "GET":
+			v = h.adminSnapshots(req.URL.Query()["url"])
+		case route == "health" && req.Method == "GET":
+			v = h.adminHealth(req.Context())
+		case route == "config" || route == "stats" || route == "snapshots" || route == "health":
+			rw.Header().Set("Allow", "GET")
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		case route == "cache":
+			rw.Header().Set("Allow", "GET, DELETE")
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		default:
+			http.NotFound(rw, req)
+			return
+		}
+
+		if err != nil {
+			h.logf("prerender admin error: %s", err)
+			http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(v)
+	})
+}
+
+func (h *Prerenderer) adminConfig() map[string]interface{} {
+	extensions := make([]string, 0, len(h.ignoredExtensions))
+	for ext := range h.ignoredExtensions {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+
+	proxies := make([]string, len(h.trustedProxies))
+	for i, n := range h.trustedProxies {
+		proxies[i] = n.String()
+	}
+
+	patterns := func(res []*regexp.Regexp) []string {
+		s := make([]string, len(res))
+		for i, re := range res {
+			s[i] = re.String()
+		}
+		return s
+	}
+
+	pathTTLs := make(map[string]float64, len(h.pathTTLs))
+	for _, rule := range h.pathTTLs {
+		pathTTLs[rule.glob] = rule.ttl.Seconds()
+	}
+
+	pathControls := make([]string, len(h.pathControls))
+	for i, rule := range h.pathControls {
+		pathControls[i] = rule.glob
+	}
+
+	encodings := make([]string, len(h.encodings))
+	for i, e := range h.encodings {
+		encodings[i] = e.coding
+	}
+
+	var http2 interface{}
+	if c := h.http2; c != nil {
+		http2 = map[string]interface{}{"cleartext": c.cleartext, "max_streams": c.maxStreams}
+	}
+
+	maxRenders := 0
+	if h.renderSlots != nil {
+		maxRenders = h.renderSlots.n
+	}
+
+	envPrefix := h.envPrefix
+	if h.envDisabled {
+		envPrefix = ""
+	}
+
+	return map[string]interface{}{
+		"service_url":            h.redactedServiceURL(),
+		"timeout_seconds":        h.client.Timeout.Seconds(),
+		"custom_transport":       h.customTransport != nil || len(h.transportOptions) > 0,
+		"service_addrs":          h.serviceAddrs,
+		"dns_cache_ttl_seconds":  h.dnsTTL.Seconds(),
+		"http2":                  http2,
+		"insecure_skip_verify":   h.insecureSkipVerify,
+		"env_prefix":             envPrefix,
+		"custom_backend":         h.backend != nil,
+		"token_set":              h.tokenProvider != nil,
+		"basic_auth_set":         h.usernameProvider != nil,
+		"bots":                   h.botUserAgents,
+		"search_bots":            h.searchBots,
+		"remote_bots":            h.botSource != nil,
+		"verify_bots":            h.verifier != nil,
+		"verify_bot_ips":         len(h.botRanges),
+		"classifier":             h.classifier != nil,
+		"class_policies":         h.classPolicies,
+		"ignored_extensions":     extensions,
+		"whitelist":              patterns(h.whitelist),
+		"blacklist":              patterns(h.blacklist),
+		"methods":                h.methods,
+		"forward_headers":        h.forwardHeaders,
+		"decision_log":           h.onDecision != nil || h.decisionLog != nil,
+		"correlation_headers":    h.correlationHeaders,
+		"accept_aware":           h.acceptAware,
+		"trusted_proxies":        proxies,
+		"canonical_host":         h.canonicalHost,
+		"allowed_hosts":          h.allowedHosts,
+		"preserve_ports":         h.preservePorts,
+		"normalize_urls":         h.normalize,
+		"drop_params":            h.dropParams,
+		"translate_fragment":     h.translateFragment,
+		"max_redirects":          h.maxRedirects,
+		"max_response_bytes":     h.maxResponseBytes,
+		"truncate_oversized":     h.truncateOversized,
+		"compress":               h.compress,
+		"content_encodings":      encodings,
+		"transformers":           len(h.transformers),
+		"cache":                  h.cache != nil,
+		"cache_ttl_seconds":      h.cacheTTL.Seconds(),
+		"path_ttls":              pathTTLs,
+		"path_render_controls":   pathControls,
+		"render_controls":        h.renderControls != nil,
+		"cache_namespace":        h.cacheNamespace,
+		"custom_cache_key":       h.cacheKeyFunc != nil,
+		"cache_bypass":           h.bypassSecret != "",
+		"stale_on_error":         h.staleOnError,
+		"min_render_interval":    h.minRenderInterval.Seconds(),
+		"locales":                h.locales,
+		"mobile_variants":        h.mobileVariants,
+		"etags":                  !h.disableETags,
+		"max_header_count":       h.maxHeaderCount,
+		"max_header_bytes":       h.maxHeaderBytes,
+		"allow_headers":          h.allowHeaders,
+		"deny_headers":           h.denyHeaders,
+		"feature_flags":          h.flags != nil,
+		"sample_rate":            h.sampleRate,
+		"async_render":           h.async != nil,
+		"render_budget_seconds":  h.renderBudget.Seconds(),
+		"respect_robots":         h.robots != nil,
+		"budget_background":      h.budgetBackground,
+		"max_renders_per_second": h.renderRate,
+		"rate_limit_overflow":    h.overflow,
+		"max_concurrent_renders": maxRenders,
+		"concurrency_overflow":   h.concurrencyOverflow,
+		"faults":                 h.faults,
+	}
+}
+
+func (h *Prerenderer) adminStats(ctx context.Context) (map[string]interface{}, error) {
+	load := h.Load()
+	stats := map[string]interface{}{
+		"in_flight":       load.InFlight,
+		"renders":         load.Renders,
+		"errors":          load.Errors,
+		"latency_seconds": load.Latency.Seconds(),
+		"queued":          load.Queued,
+		"saturated":       load.Saturated,
+		"throttled":       load.Throttled,
+		"new_conns":       load.NewConns,
+		"reused_conns":    load.ReusedConns,
+		"tls_handshakes":  load.TLSHandshakes,
+		"tls_resumed":     load.TLSResumed,
+	}
+
+	if h.cache != nil {
+		keys, err := h.cache.Keys(ctx, h.baseKey(""))
+		if err != nil {
+			return nil, err
+		}
+		stats["cached_pages"] = len(keys)
+	}
+
+	if len(h.tenants) > 0 {
+		hosts := make(map[string]interface{}, len(h.tenants))
+		for host, t := range h.tenants {
+			tstats, err := t.adminStats(ctx)
+			if err != nil {
+				return nil, err
+			}
+			hosts[host] = tstats
+		}
+		stats["hosts"] = hosts
+	}
+
+	return stats, nil
+}
+
+func (h *Prerenderer) adminKeys(ctx context.Context, prefix string) ([]string, error) {
+	if h.cache == nil {
+		return []string{}, nil
+	}
+
+	keys, err := h.cache.Keys(ctx, h.baseKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (h *Prerenderer) adminPurge(ctx context.Context, query map[string][]string) (map[string]interface{}, error) {
+	var (
+		purged int
+		err    error
+	)
+
+	switch {
+	case len(query["url"]) > 0:
+		for _, rawurl := range query["url"] {
+			var n int
+			n, err = h.Purge(ctx, rawurl)
+			purged += n
+			if err != nil {
+				break
+			}
+		}
+	case len(query["prefix"]) > 0:
+		purged, err = h.PurgePrefix(ctx, query["prefix"][0])
+	default:
+		purged, err = h.PurgeAll(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"purged": purged}, nil
+}
+
+func (h *Prerenderer) adminSnapshots(urls []string) []map[string]interface{} {
+	statuses := h.SnapshotStatus(urls)
+	out := make([]map[string]interface{}, len(statuses))
+	for i, s := range statuses {
+		out[i] = map[string]interface{}{
+			"url":    s.URL,
+			"cached": s.Cached,
+		}
+		if s.Err != nil {
+			out[i]["error"] = s.Err.Error()
+		}
+		if s.Cached {
+			out[i]["age_seconds"] = s.Age.Seconds()
+			out[i]["size"] = s.Size
+			out[i]["status"] = s.Status
+			out[i]["next_refresh"] = s.NextRefresh
+		}
+	}
+	return out
+}
+
+// redactedServiceURL returns the service URL without its credentials.
+func (h *Prerenderer) redactedServiceURL() string {
+	u, err := url.Parse(h.prerenderServiceURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// adminHealth probes the prerender service. Any response below 500 counts
+// as healthy as the service root is not guaranteed to serve a page. Custom
+// render backends are not probed, as their requests can't be derived.
+func (h *Prerenderer) adminHealth(ctx context.Context) map[string]interface{} {
+	if h.backend != nil {
+		return map[string]interface{}{"backend": fmt.Sprintf("%T", h.backend), "probed": false}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	health := map[string]interface{}{"service_url": h.redactedServiceURL()}
+
+	req, err := http.NewRequest("HEAD", h.serviceURL(), nil)
+	if err != nil {
+		health["healthy"] = false
+		health["error"] = err.Error()
+		return health
+	}
+
+	start := time.Now()
+	resp, err := h.client.Do(req.WithContext(ctx))
+	health["latency_seconds"] = time.Since(start).Seconds()
+	if err != nil {
+		health["healthy"] = false
+		health["error"] = err.Error()
+		return health
+	}
+	resp.Body.Close()
+
+	health["healthy"] = resp.StatusCode < 500
+	health["status"] = resp.StatusCode
+	return health
+}
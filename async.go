@@ -0,0 +1,100 @@
+package prerender
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// AsyncRender serves the app for pages which aren't cached yet and renders
+// them in the background, so only the following requests are served the
+// prerendered page. It suits very large sites, where waiting for renders
+// is too slow. It requires a Cache.
+func AsyncRender() Option {
+	return func(h *Prerenderer) {
+		h.async = new(asyncRenders)
+	}
+}
+
+// asyncRenders tracks the background renders of AsyncRender by cache key,
+// so concurrent requests for a page render it once.
+type asyncRenders struct {
+	mtx  sync.Mutex
+	keys map[string]struct{}
+}
+
+// start reports whether a render of key may start, and records it.
+func (a *asyncRenders) start(key string) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if _, found := a.keys[key]; found {
+		return false
+	}
+	if a.keys == nil {
+		a.keys = make(map[string]struct{})
+	}
+	a.keys[key] = struct{}{}
+	return true
+}
+
+func (a *asyncRenders) done(key string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	delete(a.keys, key)
+}
+
+// renderAsync renders the page at u for req in the background and caches
+// it under key, unless it is already being rendered or the render limits
+// are reached.
+func (h *Prerenderer) renderAsync(req *http.Request, u *url.URL, key string, reason Reason, class BotClass) {
+	if !h.async.start(key) {
+		return
+	}
+
+	// The app may modify req, and its context ends with the response.
+	req2 := req.Clone(context.WithoutCancel(req.Context()))
+
+	go func() {
+		defer h.async.done(key)
+
+		if h.renderSlots != nil {
+			if !h.renderSlots.acquire(req2.Context()) {
+				h.load.saturate()
+				h.logReqf(req2, "prerender async: too many concurrent renders: %s", u)
+				return
+			}
+			defer h.releaseSlot()
+		}
+
+		if h.renderLimiter != nil && !h.renderLimiter.allow() {
+			h.load.throttle()
+			h.logReqf(req2, "prerender async: render rate limit exceeded: %s", u)
+			return
+		}
+
+		start := time.Now()
+		snap, err := h.render(req2, u)
+		if err != nil {
+			h.logReqf(req2, "prerender async error: %s: %s", u, err)
+			return
+		}
+		h.store(req2.Context(), key, snap)
+
+		h.emitRender(RenderEvent{
+			URL:         u,
+			UserAgent:   req2.UserAgent(),
+			Credits:     1,
+			Bytes:       int64(len(snap.Body)),
+			Duration:    time.Since(start),
+			Status:      snap.Status,
+			Reason:      reason,
+			Class:       class,
+			Correlation: h.correlation(req2),
+			Async:       true,
+		})
+	}()
+}
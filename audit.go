@@ -0,0 +1,119 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Decision records whether a request was prerendered and why.
+type Decision struct {
+	Time time.Time `json:"time"`
+	// URL is the original URL of the request (see OriginalURL).
+	URL       string `json:"url"`
+	Method    string `json:"method"`
+	UserAgent string `json:"user_agent"`
+	Prerender bool   `json:"prerender"`
+	Reason    Reason `json:"reason"`
+	// Detail qualifies the reason: the matching bot pattern for ReasonBot,
+	// the class for ReasonClass and ReasonBlocked, the pattern for
+	// ReasonBlacklist, the extension for ReasonIgnoredExtension, the
+	// method for ReasonMethod and the robots.txt pattern for ReasonRobots.
+	Detail string `json:"detail,omitempty"`
+	// Correlation are the correlation headers of the request, see
+	// CorrelationHeaders.
+	Correlation http.Header `json:"correlation,omitempty"`
+}
+
+// Code returns the reason code of d, like "bot:googlebot" or "not_bot".
+func (d Decision) Code() string {
+	if d.Detail == "" {
+		return string(d.Reason)
+	}
+	return string(d.Reason) + ":" + d.Detail
+}
+
+// OnDecision registers fn to be called with the decision for every request,
+// including the requests passed through to the app.
+func OnDecision(fn func(Decision)) Option {
+	return func(h *Prerenderer) {
+		h.onDecision = fn
+	}
+}
+
+// DecisionLog writes the decision for every request to w as a line of JSON
+// with its reason code, to debug which requests crawlers are served:
+//
+//	{"time":"...","url":"https://example.com/","method":"GET","user_agent":"Googlebot/2.1","prerender":true,"reason":"bot","detail":"googlebot","code":"bot:googlebot"}
+func DecisionLog(w io.Writer) Option {
+	var mtx sync.Mutex
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return func(h *Prerenderer) {
+		h.decisionLog = func(d Decision) {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			err := enc.Encode(struct {
+				Decision
+				Code string `json:"code"`
+			}{d, d.Code()})
+			if err != nil {
+				h.logf("prerender decision log error: %s", err)
+			}
+		}
+	}
+}
+
+// Decide returns the decision for req, like ShouldPrerender with the
+// reason.
+func (h *Prerenderer) Decide(req *http.Request) Decision {
+	if t := h.tenantFor(req); t != nil {
+		return t.Decide(req)
+	}
+
+	req, marked := h.stripRendererMarker(req)
+	if OriginalURL(req.Context()) == nil {
+		if u, err := h.originalURL(req); err == nil {
+			req = req.WithContext(context.WithValue(req.Context(), originalURLKey{}, u))
+		}
+	}
+	d := Decision{Reason: ReasonRenderer}
+	if !marked {
+		d = h.decide(req)
+	}
+	return h.completeDecision(req, d)
+}
+
+// emitDecision completes d with req and reports it.
+func (h *Prerenderer) emitDecision(req *http.Request, d Decision) {
+	if h.onDecision == nil && h.decisionLog == nil {
+		return
+	}
+
+	d = h.completeDecision(req, d)
+	if h.onDecision != nil {
+		h.onDecision(d)
+	}
+	if h.decisionLog != nil {
+		h.decisionLog(d)
+	}
+}
+
+// completeDecision fills in the request fields of d.
+func (h *Prerenderer) completeDecision(req *http.Request, d Decision) Decision {
+	d.Time = time.Now()
+	if u, err := h.requestURL(req); err == nil {
+		d.URL = u.String()
+	} else {
+		d.URL = req.URL.String()
+	}
+	d.Method = req.Method
+	d.UserAgent = req.UserAgent()
+	d.Correlation = h.correlation(req)
+	return d
+}
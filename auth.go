@@ -0,0 +1,42 @@
+package prerender
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const x_PRERENDER_SIGNATURE = "X-Prerender-Signature"
+
+// authorized reports whether req is authenticated with secret, either by
+// sending it as a bearer token:
+//
+//	Authorization: Bearer <secret>
+//
+// or by signing body with HMAC-SHA256:
+//
+//	X-Prerender-Signature: sha256=<hex digest>
+func authorized(req *http.Request, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
+
+	if token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); found {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+
+	if sig, found := strings.CutPrefix(req.Header.Get(x_PRERENDER_SIGNATURE), "sha256="); found {
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+
+	return false
+}
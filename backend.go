@@ -0,0 +1,109 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// A RenderRequest describes a page to render.
+type RenderRequest struct {
+	// URL is the URL of the page.
+	URL *url.URL
+	// Request is the request of the crawler. Use OriginalURL with its
+	// context to get the original URL of the page.
+	Request *http.Request
+	// Mobile is set when the page is rendered for a mobile crawler (see
+	// MobileVariants).
+	Mobile bool
+	// Controls are the RenderControls of the page, or nil.
+	Controls *RenderControls
+}
+
+// A Backend builds the requests sent to a render service. The handler adds
+// the User-Agent, forwarded headers and credentials to the request.
+//
+// By default pages are rendered with a GET request to the service URL
+// followed by the escaped page URL, like prerender.io expects.
+type Backend interface {
+	NewRequest(ctx context.Context, r *RenderRequest) (*http.Request, error)
+}
+
+// RenderBackend sets the backend which builds requests to the render
+// service.
+func RenderBackend(b Backend) Option {
+	return func(h *Prerenderer) {
+		h.backend = b
+	}
+}
+
+// JSONBackend returns a Backend for render services which expect a POST
+// request with a JSON body containing the page URL and the given options:
+//
+//	{"url": "https://example.com/page", "waitFor": "#app"}
+//
+// Mobile renders (see MobileVariants) add "mobile": true, and the Params of
+// RenderControls are added over options.
+//
+// Requests carry an Idempotency-Key header derived from the body, and their
+// body can be replayed, so they are safe to retry.
+func JSONBackend(endpoint string, options map[string]interface{}) Backend {
+	return &jsonBackend{endpoint: endpoint, options: options}
+}
+
+type jsonBackend struct {
+	endpoint string
+	options  map[string]interface{}
+}
+
+func (b *jsonBackend) NewRequest(ctx context.Context, r *RenderRequest) (*http.Request, error) {
+	doc := make(map[string]interface{}, len(b.options)+1)
+	for k, v := range b.options {
+		doc[k] = v
+	}
+	if r.Controls != nil {
+		for k, v := range r.Controls.Params {
+			doc[k] = v
+		}
+	}
+	doc["url"] = r.URL.String()
+	if r.Mobile {
+		doc["mobile"] = true
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// NewRequest sets ContentLength and GetBody for a bytes.Reader.
+	req, err := http.NewRequestWithContext(ctx, "POST", b.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(sum[:16]))
+
+	return req, nil
+}
+
+// newServiceRequest returns the request which renders the page at u.
+func (h *Prerenderer) newServiceRequest(ctx context.Context, req1 *http.Request, u *url.URL) (*http.Request, error) {
+	mobile := h.isMobile(req1)
+
+	if h.backend == nil {
+		rawurl := h.buildApiUrl(u)
+		if mobile {
+			rawurl += "?mobile"
+		}
+		return http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+	}
+
+	return h.backend.NewRequest(ctx, &RenderRequest{URL: u, Request: req1, Mobile: mobile, Controls: h.controls(u)})
+}
@@ -0,0 +1,274 @@
+// Package boltstore stores prerendered pages in an embedded bbolt database,
+// so they survive restarts without an external service.
+//
+// bbolt files never shrink; call Compact periodically (like after purging
+// the cache) to reclaim the space of deleted snapshots.
+package boltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fd/prerender"
+)
+
+var (
+	// snapshotsBucket maps keys to the time they were written (8 byte Unix
+	// nanoseconds) followed by the snapshot as JSON.
+	snapshotsBucket = []byte("snapshots")
+	// writesBucket orders the keys by write time: it maps the write time
+	// followed by the key to nothing.
+	writesBucket = []byte("writes")
+)
+
+// compactTxSize is the transaction size used by Compact.
+const compactTxSize = 64 << 20
+
+// Store is a prerender.Store backed by a bbolt database. It evicts the
+// least recently written snapshots when they exceed MaxBytes.
+type Store struct {
+	path string
+
+	// MaxBytes limits the total size of the stored snapshots (not of the
+	// database file). Zero means no limit.
+	MaxBytes int64
+	// Retention is the time snapshots are kept after they expire, so they
+	// can still be served within a MinRenderInterval. Older snapshots are
+	// deleted when they are read.
+	Retention time.Duration
+
+	// mtx guards db, which Compact replaces.
+	mtx sync.RWMutex
+	db  *bolt.DB
+	// size is only changed by write transactions, which bbolt runs one at
+	// a time.
+	size atomic.Int64
+}
+
+// Open opens or creates the database at path.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) open() error {
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(writesBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			size += int64(len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	s.db = db
+	s.size.Store(size)
+	return nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.db.Close()
+}
+
+// Get implements prerender.Store.
+func (s *Store) Get(ctx context.Context, key string) (*prerender.Snapshot, error) {
+	s.mtx.RLock()
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(snapshotsBucket).Get([]byte(key)); v != nil {
+			// v is only valid during the transaction.
+			data = append([]byte(nil), v[8:]...)
+		}
+		return nil
+	})
+	s.mtx.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, prerender.ErrNotCached
+	}
+
+	var snap prerender.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+
+	if !snap.ExpiresAt.IsZero() && time.Now().After(snap.ExpiresAt.Add(s.Retention)) {
+		if err := s.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+		return nil, prerender.ErrNotCached
+	}
+	return &snap, nil
+}
+
+// Put implements prerender.Store.
+func (s *Store) Put(ctx context.Context, key string, snap *prerender.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		freed, err := s.remove(tx, k)
+		if err != nil {
+			return err
+		}
+
+		written := make([]byte, 8)
+		binary.BigEndian.PutUint64(written, uint64(time.Now().UnixNano()))
+
+		v := append(written, data...)
+		if err := tx.Bucket(snapshotsBucket).Put(k, v); err != nil {
+			return err
+		}
+		if err := tx.Bucket(writesBucket).Put(append(written, k...), nil); err != nil {
+			return err
+		}
+
+		evicted, err := s.evict(tx, s.size.Load()+int64(len(v))-freed)
+		if err != nil {
+			return err
+		}
+		s.size.Add(int64(len(v)) - freed - evicted)
+		return nil
+	})
+}
+
+// Delete implements prerender.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		freed, err := s.remove(tx, []byte(key))
+		if err != nil {
+			return err
+		}
+		s.size.Add(-freed)
+		return nil
+	})
+}
+
+// Keys implements prerender.Store.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(snapshotsBucket).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Size returns the total size of the stored snapshots.
+func (s *Store) Size() int64 {
+	return s.size.Load()
+}
+
+// Compact rewrites the database to reclaim the space of deleted snapshots.
+// Other calls block while it runs.
+func (s *Store) Compact() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tmp := s.path + ".compact"
+	os.Remove(tmp)
+
+	dst, err := bolt.Open(tmp, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, s.db, compactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// remove deletes key in tx, if it exists, and returns the size freed.
+func (s *Store) remove(tx *bolt.Tx, key []byte) (int64, error) {
+	b := tx.Bucket(snapshotsBucket)
+
+	v := b.Get(key)
+	if v == nil {
+		return 0, nil
+	}
+
+	if err := tx.Bucket(writesBucket).Delete(append(append([]byte(nil), v[:8]...), key...)); err != nil {
+		return 0, err
+	}
+	return int64(len(v)), b.Delete(key)
+}
+
+// evict removes the least recently written snapshots in tx until size is
+// within MaxBytes, and returns the size freed.
+func (s *Store) evict(tx *bolt.Tx, size int64) (int64, error) {
+	var evicted int64
+
+	c := tx.Bucket(writesBucket).Cursor()
+	for k, _ := c.First(); k != nil && s.MaxBytes > 0 && size-evicted > s.MaxBytes; k, _ = c.First() {
+		freed, err := s.remove(tx, append([]byte(nil), k[8:]...))
+		if err != nil {
+			return evicted, err
+		}
+		evicted += freed
+	}
+	return evicted, nil
+}
@@ -0,0 +1,244 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A BotMatcher recognizes the User-Agents of bots.
+type BotMatcher interface {
+	MatchBot(userAgent string) bool
+}
+
+// MatchBots replaces the bot list with a custom matcher.
+func MatchBots(m BotMatcher) Option {
+	return func(h *Prerenderer) {
+		h.botUserAgents, h.botMatcher = nil, m
+	}
+}
+
+// BotPatterns replaces the bot list with patterns, all matched
+// case-insensitively against the User-Agent:
+//
+//	facebookexternalhit        contains the substring
+//	prefix:Pinterest/          starts with the prefix
+//	exact:Slackbot 1.0         equals the string
+//	regexp:^Twitterbot/\d      matches the regular expression
+func BotPatterns(patterns ...string) Option {
+	m, err := compileBotPatterns(patterns)
+	return func(h *Prerenderer) {
+		h.botUserAgents, h.botMatcher = patterns, m
+		h.optionError(err)
+	}
+}
+
+// botPatterns is the BotMatcher of BotPatterns.
+type botPatterns struct {
+	substrings []string
+	prefixes   []string
+	exact      map[string]struct{}
+	res        []*regexp.Regexp
+}
+
+func substringMatcher(userAgents []string) *botPatterns {
+	m := &botPatterns{substrings: make([]string, len(userAgents))}
+	for i, ua := range userAgents {
+		m.substrings[i] = strings.ToLower(ua)
+	}
+	return m
+}
+
+func compileBotPatterns(patterns []string) (*botPatterns, error) {
+	m := &botPatterns{exact: make(map[string]struct{})}
+	for _, pattern := range patterns {
+		kind, value, found := strings.Cut(pattern, ":")
+		if !found {
+			kind, value = "", pattern
+		}
+
+		switch kind {
+		case "prefix":
+			m.prefixes = append(m.prefixes, strings.ToLower(value))
+		case "exact":
+			m.exact[strings.ToLower(value)] = struct{}{}
+		case "regexp":
+			re, err := regexp.Compile("(?i)" + value)
+			if err != nil {
+				return m, fmt.Errorf("invalid bot pattern: %w", err)
+			}
+			m.res = append(m.res, re)
+		default:
+			m.substrings = append(m.substrings, strings.ToLower(pattern))
+		}
+	}
+	return m, nil
+}
+
+func (m *botPatterns) MatchBot(userAgent string) bool {
+	_, ok := m.match(userAgent)
+	return ok
+}
+
+// match returns the pattern matching userAgent.
+func (m *botPatterns) match(userAgent string) (string, bool) {
+	ua := strings.ToLower(userAgent)
+	if _, found := m.exact[ua]; found {
+		return "exact:" + ua, true
+	}
+	for _, s := range m.substrings {
+		if strings.Contains(ua, s) {
+			return s, true
+		}
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(ua, prefix) {
+			return "prefix:" + prefix, true
+		}
+	}
+	for _, re := range m.res {
+		if re.MatchString(userAgent) {
+			return "regexp:" + strings.TrimPrefix(re.String(), "(?i)"), true
+		}
+	}
+	return "", false
+}
+
+// matchBot reports whether m matches userAgent, with the matching pattern
+// when m is a pattern list.
+func matchBot(m BotMatcher, userAgent string) (string, bool) {
+	if p, ok := m.(*botPatterns); ok {
+		return p.match(userAgent)
+	}
+	return "", m.MatchBot(userAgent)
+}
+
+// A BotSource provides a bot matcher which may change over time.
+type BotSource interface {
+	// BotMatcher returns the current matcher, or nil when none is
+	// available.
+	BotMatcher() BotMatcher
+}
+
+// RemoteBots matches User-Agents with the matcher provided by src, like a
+// BotListPoller, instead of the bot list. The bot list is used while src
+// provides no matcher.
+func RemoteBots(src BotSource) Option {
+	return func(h *Prerenderer) {
+		h.botSource = src
+	}
+}
+
+// BotListPoller is a BotSource which periodically fetches a maintained list
+// of crawler User-Agents from a URL, so new bots are recognized without a
+// redeploy. The document is either a JSON array of BotPatterns, or an array
+// of objects with a regular expression "pattern", like the
+// crawler-user-agents dataset.
+type BotListPoller struct {
+	URL string
+	// Interval is the time between fetches, by default a minute.
+	Interval time.Duration
+	Client   *http.Client
+
+	mtx     sync.RWMutex
+	matcher BotMatcher
+}
+
+// NewBotListPoller returns a BotListPoller for url. Call Run to start
+// polling.
+func NewBotListPoller(url string, interval time.Duration) *BotListPoller {
+	return &BotListPoller{URL: url, Interval: interval}
+}
+
+// BotMatcher returns the matcher of the most recently fetched bot list.
+func (p *BotListPoller) BotMatcher() BotMatcher {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return p.matcher
+}
+
+// Run polls the bot list until ctx is done. Failed fetches keep the
+// previous list and are reported to logf when it is not nil.
+func (p *BotListPoller) Run(ctx context.Context, logf func(format string, args ...interface{})) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Fetch(ctx); err != nil && logf != nil {
+			logf("prerender bot list error: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetch fetches the bot list once.
+func (p *BotListPoller) Fetch(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var doc []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	patterns := make([]string, 0, len(doc))
+	for _, raw := range doc {
+		var pattern string
+		if err := json.Unmarshal(raw, &pattern); err != nil {
+			var entry struct {
+				Pattern string `json:"pattern"`
+			}
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return err
+			}
+			pattern = "regexp:" + entry.Pattern
+		}
+		if pattern != "" && pattern != "regexp:" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("empty bot list")
+	}
+
+	m, err := compileBotPatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	p.matcher = m
+	p.mtx.Unlock()
+	return nil
+}
@@ -0,0 +1,119 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// errBudgetExceeded is returned for renders abandoned after the budget.
+var errBudgetExceeded = errors.New("render budget exceeded")
+
+// RenderBudget serves the app instead of the prerendered page when the render
+// takes longer than budget, including the wait for a slot of
+// MaxConcurrentRenders, so slow renders don't stall crawlers. The
+// abandoned render is canceled, or with background it finishes to populate
+// the cache for the next requests. Cache hits are not affected.
+func RenderBudget(budget time.Duration, background bool) Option {
+	return func(h *Prerenderer) {
+		h.renderBudget, h.budgetBackground = budget, background
+	}
+}
+
+// budgetRender renders like proxyRender, but serves req1 with the app when
+// the render exceeds the budget. The budget includes the wait for a render
+// slot, which budgetRender takes like saturated and releases when the render
+// ends.
+func (h *Prerenderer) budgetRender(rw http.ResponseWriter, req1 *http.Request, u *url.URL, key string) error {
+	deadline := time.Now().Add(h.renderBudget)
+	if h.renderSlots != nil {
+		ctx, cancel := context.WithDeadline(req1.Context(), deadline)
+		ok := h.renderSlots.acquire(ctx)
+		cancel()
+		switch {
+		case !ok && !time.Now().Before(deadline) && req1.Context().Err() == nil:
+			return h.serveOverBudget(rw, req1)
+		case !ok:
+			h.serveSaturated(rw, req1, key)
+			return errSaturated
+		}
+	}
+	if h.throttled(rw, req1, key) {
+		h.releaseSlot()
+		return errThrottled
+	}
+
+	ctx := req1.Context()
+	if h.budgetBackground {
+		ctx = context.WithoutCancel(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The app may modify req1 while the render is still running.
+	req2 := req1.Clone(ctx)
+
+	buf := &bufferedResponse{header: make(http.Header)}
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		defer h.releaseSlot()
+		done <- h.proxyRender(buf, req2, u, key)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		buf.writeTo(rw)
+		return err
+	case <-timer.C:
+	}
+
+	if !h.budgetBackground {
+		cancel()
+	}
+	return h.serveOverBudget(rw, req1)
+}
+
+// serveOverBudget serves req1 with the app once the render budget is
+// exceeded.
+func (h *Prerenderer) serveOverBudget(rw http.ResponseWriter, req1 *http.Request) error {
+	h.logReqf(req1, "prerender: render budget of %s exceeded, serving the app: %q", h.renderBudget, req1.URL)
+	h.sub.ServeHTTP(rw, req1)
+	return errBudgetExceeded
+}
+
+// bufferedResponse is an http.ResponseWriter keeping the response in memory.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// writeTo writes the buffered response to rw.
+func (b *bufferedResponse) writeTo(rw http.ResponseWriter) {
+	copyHeader(rw.Header(), b.header)
+	if b.status != 0 {
+		rw.WriteHeader(b.status)
+	}
+	rw.Write(b.body.Bytes())
+}
@@ -0,0 +1,63 @@
+package prerender
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	x_PRERENDER_BYPASS = "X-Prerender-Bypass"
+	bypassParam        = "prerender_nocache"
+)
+
+type bypassKey struct{}
+
+// CacheBypass lets requests carrying secret in an X-Prerender-Bypass header
+// or a prerender_nocache query parameter skip the cache: the page is
+// rendered again and the cached snapshot replaced. It supports debugging
+// and verifying pages after publishing. The parameter is removed from the
+// URL before the page is rendered.
+func CacheBypass(secret string) Option {
+	return func(h *Prerenderer) {
+		h.bypassSecret = secret
+	}
+}
+
+// stripBypass removes the cache bypass parameter from req and reports
+// whether req bypasses the cache.
+func (h *Prerenderer) stripBypass(req *http.Request) (*http.Request, bool) {
+	if h.bypassSecret == "" {
+		return req, false
+	}
+
+	bypass := validBypass(req.Header.Get(x_PRERENDER_BYPASS), h.bypassSecret)
+
+	if q := req.URL.Query(); q.Has(bypassParam) {
+		if validBypass(q.Get(bypassParam), h.bypassSecret) {
+			bypass = true
+		}
+
+		q.Del(bypassParam)
+		u := *req.URL
+		u.RawQuery = q.Encode()
+		req = req.Clone(req.Context())
+		req.URL = &u
+		req.RequestURI = u.RequestURI()
+	}
+
+	if !bypass {
+		return req, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), bypassKey{}, true)), true
+}
+
+func validBypass(value, secret string) bool {
+	return value != "" && subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
+
+// bypassesCache reports whether req skips the cache.
+func bypassesCache(req *http.Request) bool {
+	bypass, _ := req.Context().Value(bypassKey{}).(bool)
+	return bypass
+}
@@ -0,0 +1,478 @@
+package prerender
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotCached is returned by a Store which has no snapshot for a key.
+var ErrNotCached = errors.New("prerender: not cached")
+
+// A Snapshot is a prerendered page.
+type Snapshot struct {
+	// URL is the original URL of the page.
+	URL    string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Gzip is the gzip encoded body as returned by the prerender service,
+	// or compressed with Compress, if any.
+	Gzip []byte
+	// Encoded are the bodies encoded with the codings of ContentEncoding.
+	Encoded map[string][]byte
+	// ETag is the strong entity tag of Body.
+	ETag string
+
+	RenderedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// A Store persists snapshots. Stores must be safe for concurrent use and
+// must not modify the snapshots they are given.
+type Store interface {
+	// Get returns the snapshot for key or ErrNotCached.
+	Get(ctx context.Context, key string) (*Snapshot, error)
+	Put(ctx context.Context, key string, snap *Snapshot) error
+	Delete(ctx context.Context, key string) error
+	// Keys returns the keys starting with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Cache caches prerendered pages in store for ttl.
+func Cache(store Store, ttl time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.cache, h.cacheTTL = store, ttl
+	}
+}
+
+// CacheNamespace prefixes all cache keys with version (like the git SHA of
+// the app build), so a new deploy invalidates all snapshots rendered from
+// the previous build.
+func CacheNamespace(version string) Option {
+	return func(h *Prerenderer) {
+		h.cacheNamespace = version
+	}
+}
+
+// PathTTL caches the pages with paths matching pattern for ttl instead of
+// the TTL given to Cache. In patterns "*" matches within a path segment and
+// "**" across segments, like "/news/**" (which also matches "/news") or
+// "/docs/*/index.html". The first matching pattern applies.
+func PathTTL(pattern string, ttl time.Duration) Option {
+	re := compileGlob(pattern)
+	return func(h *Prerenderer) {
+		h.pathTTLs = append(h.pathTTLs, pathTTL{glob: pattern, re: re, ttl: ttl})
+	}
+}
+
+type pathTTL struct {
+	glob string
+	re   *regexp.Regexp
+	ttl  time.Duration
+}
+
+// compileGlob compiles a path pattern of PathTTL.
+func compileGlob(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case pattern[i:] == "/**":
+			b.WriteString("(/.*)?")
+			i += 2
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// ttl returns the cache TTL of the page at rawurl.
+func (h *Prerenderer) ttl(rawurl string) time.Duration {
+	if len(h.pathTTLs) == 0 {
+		return h.cacheTTL
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return h.cacheTTL
+	}
+
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	for _, rule := range h.pathTTLs {
+		if rule.re.MatchString(p) {
+			return rule.ttl
+		}
+	}
+	return h.cacheTTL
+}
+
+// CacheKeyFunc sets the function which returns the cache key of a crawler
+// request, for example to ignore query parameters or to add an A/B bucket.
+// Use OriginalURL with the context of the request to get the page URL. The
+// key is prefixed with the CacheNamespace.
+//
+// Purging a URL deletes the keys equal to the URL or starting with the URL
+// followed by "|", so keys should have that form, like
+// "https://example.com/page|bucket=b". Recache and SnapshotStatus call fn
+// with a GET request without headers.
+func CacheKeyFunc(fn func(req *http.Request) string) Option {
+	return func(h *Prerenderer) {
+		h.cacheKeyFunc = fn
+	}
+}
+
+// cacheKey returns the cache key of the variant of the original URL u
+// requested by req. A nil req selects the default variant.
+func (h *Prerenderer) cacheKey(req *http.Request, u *url.URL) string {
+	if h.cacheKeyFunc != nil {
+		if req == nil {
+			ctx := context.WithValue(context.Background(), originalURLKey{}, u)
+			req = (&http.Request{Method: "GET", URL: u, Host: u.Host, Header: make(http.Header)}).WithContext(ctx)
+		}
+		return h.baseKey(h.cacheKeyFunc(req))
+	}
+
+	key := h.baseKey(u.String())
+	if locale := h.locale(req); locale != "" {
+		key += "|locale=" + locale
+	}
+	if h.isMobile(req) {
+		key += "|device=mobile"
+	}
+	return key
+}
+
+// baseKey returns the cache key prefix of all variants of rawurl.
+func (h *Prerenderer) baseKey(rawurl string) string {
+	if h.cacheNamespace != "" {
+		return h.cacheNamespace + ":" + rawurl
+	}
+	return rawurl
+}
+
+// lookup returns the cached snapshot for key (even when it expired) or nil.
+// The empty key is never cached.
+func (h *Prerenderer) lookup(ctx context.Context, key string) *Snapshot {
+	if h.cache == nil || key == "" {
+		return nil
+	}
+
+	snap, err := h.cache.Get(ctx, key)
+	if err != nil {
+		if err != ErrNotCached {
+			h.logf("prerender cache error: %s", err)
+		}
+		return nil
+	}
+	return snap
+}
+
+// MinRenderInterval enforces a minimum interval between renders of the same
+// page, independent of the cache TTL. Within the interval the cached
+// snapshot is served even when it expired, protecting against crawlers
+// which re-fetch a page every few seconds. It requires a Cache.
+func MinRenderInterval(d time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.minRenderInterval = d
+	}
+}
+
+// cached returns the cached snapshot for key which can be served without
+// rendering, or nil.
+func (h *Prerenderer) cached(ctx context.Context, key string) *Snapshot {
+	snap := h.lookup(ctx, key)
+	if snap == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(snap.ExpiresAt) || now.Sub(snap.RenderedAt) < h.minRenderInterval {
+		return snap
+	}
+	return nil
+}
+
+// store caches snap under key. Server errors are not cached, nor is the
+// empty key.
+func (h *Prerenderer) store(ctx context.Context, key string, snap *Snapshot) {
+	if h.cache == nil || key == "" || snap.Status >= 500 {
+		return
+	}
+
+	snap.ExpiresAt = snap.RenderedAt.Add(h.ttl(snap.URL))
+
+	if err := h.cache.Put(ctx, key, snap); err != nil {
+		h.logf("prerender cache error: %s", err)
+	}
+}
+
+// purgeKeys deletes the cached snapshots with keys starting with prefix.
+// When exact is set only prefix itself and its variants are deleted.
+func (h *Prerenderer) purgeKeys(ctx context.Context, prefix string, exact bool) (int, error) {
+	if h.cache == nil {
+		return 0, nil
+	}
+
+	keys, err := h.cache.Keys(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		if exact && key != prefix && !strings.HasPrefix(key, prefix+"|") {
+			continue
+		}
+		if err := h.cache.Delete(ctx, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Purge deletes all variants of the page at the absolute URL rawurl from
+// the cache, like when its content changed, and returns the number of
+// snapshots deleted.
+func (h *Prerenderer) Purge(ctx context.Context, rawurl string) (int, error) {
+	u, err := h.targetURL(rawurl)
+	if err != nil {
+		return 0, err
+	}
+	if t := h.tenants[strings.ToLower(u.Hostname())]; t != nil {
+		h = t
+	}
+	return h.purgeKeys(ctx, h.baseKey(u.String()), true)
+}
+
+// PurgePrefix deletes all pages with URLs starting with prefix, like
+// "https://example.com/products/", from the cache.
+func (h *Prerenderer) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	if u, err := url.Parse(prefix); err == nil {
+		if t := h.tenants[strings.ToLower(u.Hostname())]; t != nil {
+			h = t
+		}
+	}
+	return h.purgeKeys(ctx, h.baseKey(prefix), false)
+}
+
+// PurgeAll deletes all pages from the cache, including the pages of other
+// namespaces and hosts.
+func (h *Prerenderer) PurgeAll(ctx context.Context) (int, error) {
+	n, err := h.purgeKeys(ctx, "", false)
+	if err != nil {
+		return n, err
+	}
+
+	for _, t := range h.tenants {
+		// Tenants sharing the store find no more keys.
+		m, err := t.purgeKeys(ctx, "", false)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// CachedURLs returns the URLs of the cached pages, including the pages of
+// other hosts, once for all their variants. Keys of a CacheKeyFunc which
+// aren't URLs are skipped.
+func (h *Prerenderer) CachedURLs(ctx context.Context) ([]string, error) {
+	var (
+		urls     []string
+		seen     = make(map[string]bool)
+		handlers = []*Prerenderer{h}
+	)
+	for _, t := range h.tenants {
+		handlers = append(handlers, t)
+	}
+
+	for _, p := range handlers {
+		if p.cache == nil {
+			continue
+		}
+
+		prefix := p.baseKey("")
+		keys, err := p.cache.Keys(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			rawurl := strings.TrimPrefix(key, prefix)
+			for _, variant := range []string{"|locale=", "|device="} {
+				if i := strings.Index(rawurl, variant); i >= 0 {
+					rawurl = rawurl[:i]
+				}
+			}
+			if u, err := url.Parse(rawurl); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			if !seen[rawurl] {
+				seen[rawurl] = true
+				urls = append(urls, rawurl)
+			}
+		}
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// SnapshotStatus describes the cache state of a URL.
+type SnapshotStatus struct {
+	URL    string
+	Cached bool
+	// Age is the time since the page was rendered.
+	Age time.Duration
+	// Size is the size of the rendered page in bytes.
+	Size int
+	// Status is the status code of the last render.
+	Status int
+	// NextRefresh is the time after which the page is rendered again.
+	NextRefresh time.Time
+	// Err is set when the URL is invalid.
+	Err error
+}
+
+// SnapshotStatus returns the cache state of each of the absolute urls.
+func (h *Prerenderer) SnapshotStatus(urls []string) []SnapshotStatus {
+	var (
+		ctx      = context.Background()
+		now      = time.Now()
+		statuses = make([]SnapshotStatus, len(urls))
+	)
+
+	for i, rawurl := range urls {
+		statuses[i].URL = rawurl
+
+		u, err := h.targetURL(rawurl)
+		if err != nil {
+			statuses[i].Err = err
+			continue
+		}
+
+		t := h
+		if ht := h.tenants[strings.ToLower(u.Hostname())]; ht != nil {
+			t = ht
+		}
+
+		snap := t.lookup(ctx, t.cacheKey(nil, u))
+		if snap == nil {
+			continue
+		}
+
+		statuses[i].Cached = true
+		statuses[i].Age = now.Sub(snap.RenderedAt)
+		statuses[i].Size = len(snap.Body)
+		statuses[i].Status = snap.Status
+		statuses[i].NextRefresh = snap.ExpiresAt
+	}
+
+	return statuses
+}
+
+// MemoryStore is an in-memory Store which evicts the least recently used
+// snapshots when it is full.
+type MemoryStore struct {
+	mtx     sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	snap *Snapshot
+}
+
+// NewMemoryStore returns a MemoryStore holding at most maxEntries
+// snapshots. Zero means no limit.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		max:     maxEntries,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Snapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	elem, found := s.entries[key]
+	if !found {
+		return nil, ErrNotCached
+	}
+
+	s.lru.MoveToFront(elem)
+	return elem.Value.(*memoryEntry).snap, nil
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, key string, snap *Snapshot) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if elem, found := s.entries[key]; found {
+		elem.Value.(*memoryEntry).snap = snap
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, snap: snap})
+
+	for s.max > 0 && s.lru.Len() > s.max {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if elem, found := s.entries[key]; found {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// Keys implements Store.
+func (s *MemoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var keys []string
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
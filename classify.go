@@ -0,0 +1,178 @@
+package prerender
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A BotClass is the kind of client making a request.
+type BotClass string
+
+// Classes reported by classifiers.
+const (
+	ClassSearch  BotClass = "search"
+	ClassSocial  BotClass = "social"
+	ClassScraper BotClass = "scraper"
+	ClassHuman   BotClass = "human"
+)
+
+// A Verdict is the classification of a request.
+type Verdict struct {
+	Class BotClass
+	// Confidence is between 0 and 1.
+	Confidence float64
+}
+
+// A Classifier classifies requests.
+type Classifier interface {
+	Classify(req *http.Request) Verdict
+}
+
+// ClassifierFunc adapts a function to a Classifier.
+type ClassifierFunc func(req *http.Request) Verdict
+
+// Classify calls fn(req).
+func (fn ClassifierFunc) Classify(req *http.Request) Verdict {
+	return fn(req)
+}
+
+// A Policy is the handling of a class of requests.
+type Policy int
+
+// Policies for ClassPolicy.
+const (
+	// PolicyDefault leaves the request to the bot list and other options.
+	PolicyDefault Policy = iota
+	// PolicyPass serves the app.
+	PolicyPass
+	// PolicyRender prerenders the page without caching it.
+	PolicyRender
+	// PolicyRenderCache prerenders and caches the page.
+	PolicyRenderCache
+	// PolicyBlock responds with 403 Forbidden.
+	PolicyBlock
+)
+
+var policyNames = []string{"default", "pass", "render", "render_cache", "block"}
+
+// String returns the name of p, like "render_cache".
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Policy) UnmarshalText(text []byte) error {
+	for i, name := range policyNames {
+		if string(text) == name {
+			*p = Policy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("prerender: unknown policy %q", text)
+}
+
+// Classify classifies requests with c. Verdicts with a confidence below
+// minConfidence are ignored. The policies of the classes are set with
+// ClassPolicy.
+func Classify(c Classifier, minConfidence float64) Option {
+	return func(h *Prerenderer) {
+		h.classifier = c
+		h.minConfidence = minConfidence
+	}
+}
+
+// ClassPolicy sets the policy of the requests classified as class (see
+// Classify). A rendering policy replaces the bot list for the class;
+// requests still pass the other filters, like IgnoredExtensions, Whitelist
+// and VerifyBots. PolicyBlock blocks all requests of the class.
+//
+// The page loads of the prerender service itself are classified too. Set
+// RendererMarker so they skip classification, or a custom Classifier
+// blocking headless browsers blocks the renders as well.
+func ClassPolicy(class BotClass, p Policy) Option {
+	return func(h *Prerenderer) {
+		if h.classPolicies == nil {
+			h.classPolicies = make(map[BotClass]Policy)
+		}
+		h.classPolicies[class] = p
+	}
+}
+
+// DefaultClassifier classifies requests by their User-Agent: the search
+// engine crawlers of AllowSearchBots as search bots, the default bot list as
+// social preview bots and common HTTP libraries as scrapers. Headless
+// browsers are not scrapers, since prerender services load the app with
+// them. Other requests are classified as humans with a low confidence.
+var DefaultClassifier Classifier = ClassifierFunc(classifyUserAgent)
+
+// scraperUserAgents are classified as scrapers by DefaultClassifier.
+var scraperUserAgents = substringMatcher([]string{
+	"curl/",
+	"wget/",
+	"python-requests",
+	"python-urllib",
+	"aiohttp",
+	"scrapy",
+	"go-http-client",
+	"okhttp",
+	"java/",
+	"apache-httpclient",
+	"node-fetch",
+	"axios/",
+})
+
+var socialBotMatcher = substringMatcher(crawlerUserAgents)
+
+func classifyUserAgent(req *http.Request) Verdict {
+	ua := req.UserAgent()
+	switch {
+	case ua == "":
+		return Verdict{Class: ClassScraper, Confidence: 0.6}
+	case searchBotMatcher.MatchBot(ua):
+		return Verdict{Class: ClassSearch, Confidence: 0.8}
+	case socialBotMatcher.MatchBot(ua):
+		return Verdict{Class: ClassSocial, Confidence: 0.8}
+	case scraperUserAgents.MatchBot(ua):
+		return Verdict{Class: ClassScraper, Confidence: 0.9}
+	case strings.Contains(strings.ToLower(ua), "bot"):
+		return Verdict{Class: ClassScraper, Confidence: 0.5}
+	}
+	return Verdict{Class: ClassHuman, Confidence: 0.5}
+}
+
+type verdictKey struct{}
+
+// classify returns the verdict for req and its policy. The verdict is
+// remembered in the context of req by ServeHTTP.
+func (h *Prerenderer) classify(req *http.Request) (Verdict, Policy) {
+	if h.classifier == nil {
+		return Verdict{}, PolicyDefault
+	}
+
+	v, ok := req.Context().Value(verdictKey{}).(Verdict)
+	if !ok {
+		v = h.classifier.Classify(req)
+	}
+	if v.Confidence < h.minConfidence {
+		return v, PolicyDefault
+	}
+	return v, h.classPolicies[v.Class]
+}
+
+// withVerdict classifies req once for the rest of its handling.
+func (h *Prerenderer) withVerdict(ctx context.Context, req *http.Request) context.Context {
+	if h.classifier == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, verdictKey{}, h.classifier.Classify(req))
+}
@@ -0,0 +1,228 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	recacheEndpoint = "https://api.prerender.io/recache"
+	// maxRecacheBatch is the maximum number of URLs per recache call.
+	maxRecacheBatch = 1000
+)
+
+// Client calls the prerender.io API, for example to invalidate stale renders
+// from a deploy pipeline.
+type Client struct {
+	// Token is the prerender.io token.
+	Token string
+	// Endpoint is the recache endpoint. It defaults to
+	// https://api.prerender.io/recache.
+	Endpoint string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for token. An empty token defaults to the
+// PRERENDER_TOKEN environment variable.
+func NewClient(token string) *Client {
+	if token == "" {
+		token = os.Getenv("PRERENDER_TOKEN")
+	}
+	return &Client{Token: token}
+}
+
+// Recache asks prerender.io to render url again.
+func (c *Client) Recache(ctx context.Context, url string) error {
+	return c.recache(ctx, map[string]interface{}{
+		"prerenderToken": c.Token,
+		"url":            url,
+	})
+}
+
+// RecacheAll asks prerender.io to render all urls again. URLs are submitted
+// in batches of 1000.
+func (c *Client) RecacheAll(ctx context.Context, urls []string) error {
+	for len(urls) > 0 {
+		batch := urls
+		if len(batch) > maxRecacheBatch {
+			batch = batch[:maxRecacheBatch]
+		}
+		urls = urls[len(batch):]
+
+		err := c.recache(ctx, map[string]interface{}{
+			"prerenderToken": c.Token,
+			"urls":           batch,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) recache(ctx context.Context, doc map[string]interface{}) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = recacheEndpoint
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &RecacheError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// RecacheError is returned when prerender.io rejects a recache call.
+type RecacheError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RecacheError) Error() string {
+	return fmt.Sprintf("prerender: recache failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// BatchOptions configures RecacheBatch.
+type BatchOptions struct {
+	// Rate limits the number of recache calls per second and Burst the
+	// number of calls made at once. Zero means no limit.
+	Rate  float64
+	Burst int
+	// Concurrency is the number of concurrent calls. It defaults to 1.
+	Concurrency int
+	// Retries is the number of times a failed call is retried, with
+	// exponential backoff starting at one second.
+	Retries int
+	// Progress, when not nil, is called after each URL is done.
+	Progress func(BatchProgress)
+}
+
+// BatchProgress reports the progress of RecacheBatch.
+type BatchProgress struct {
+	// URL is the URL which was just done and Err its error, if any.
+	URL string
+	Err error
+	// Done counts the URLs done (including Failed ones) out of Total.
+	Done   int
+	Failed int
+	Total  int
+}
+
+// RecacheBatch asks prerender.io to render each of urls again, with rate
+// limiting, bounded concurrency and retries, for site-wide recaching. It
+// returns the number of URLs which failed; errors are reported to Progress.
+func (c *Client) RecacheBatch(ctx context.Context, urls []string, opts BatchOptions) (failed int, err error) {
+	var (
+		limiter  *tokenBucket
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+		queue    = make(chan string)
+		workers  = opts.Concurrency
+		progress = BatchProgress{Total: len(urls)}
+	)
+
+	if opts.Rate > 0 {
+		limiter = newTokenBucket(opts.Rate, opts.Burst)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	recache := func(url string) error {
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			if limiter != nil {
+				if err := limiter.wait(ctx); err != nil {
+					return err
+				}
+			}
+
+			err := c.Recache(ctx, url)
+			if err == nil || attempt >= opts.Retries || !retryable(err) {
+				return err
+			}
+
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range queue {
+				err := recache(url)
+
+				mtx.Lock()
+				progress.URL, progress.Err = url, err
+				progress.Done++
+				if err != nil {
+					progress.Failed++
+				}
+				if opts.Progress != nil {
+					opts.Progress(progress)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, url := range urls {
+		select {
+		case queue <- url:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return progress.Failed, ctx.Err()
+}
+
+// retryable reports whether a failed recache call may succeed when retried.
+func retryable(err error) bool {
+	if e, ok := err.(*RecacheError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+// Command prerenderctl debugs prerendering without writing Go code.
+//
+// Usage:
+//
+//	prerenderctl check [-ua agent] [-method GET] [-accept type] URL
+//	prerenderctl render [-ua agent] [-service url] URL
+//	prerenderctl warm [-concurrency n] [-rate r] [-robots] [-robots-agent ua] SITEMAP...
+//	prerenderctl purge -endpoint url -secret s [-all | -prefix p | URL...]
+//
+// The prerender service token is read from PRERENDER_TOKEN.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/fd/prerender"
+	"github.com/fd/prerender/warmer"
+)
+
+const defaultUserAgent = "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)"
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("prerenderctl: ")
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "check":
+		err = check(args)
+	case "render":
+		err = render(args)
+	case "warm":
+		err = warm(ctx, args)
+	case "purge":
+		err = purge(ctx, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: prerenderctl check|render|warm|purge [flags] args...")
+	os.Exit(2)
+}
+
+// check reports whether a request for a URL would be prerendered. It exits
+// with status 1 when it would not.
+func check(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	ua := fs.String("ua", defaultUserAgent, "User-Agent of the request")
+	method := fs.String("method", "GET", "method of the request")
+	accept := fs.String("accept", "text/html", "Accept header of the request")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("check: expected one URL")
+	}
+
+	req, err := newRequest(*method, fs.Arg(0), *ua)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", *accept)
+
+	d := prerender.Handler(nil).Decide(req)
+	if !d.Prerender {
+		fmt.Printf("pass through (%s)\n", d.Code())
+		os.Exit(1)
+	}
+	fmt.Printf("prerender (%s)\n", d.Code())
+	return nil
+}
+
+// render renders a URL through the prerender service and writes the page to
+// stdout and the status and headers to stderr. It exits with status 1 when
+// the response is an error.
+func render(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	ua := fs.String("ua", defaultUserAgent, "User-Agent of the request")
+	service := fs.String("service", "", "URL of the prerender service")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("render: expected one URL")
+	}
+
+	var options []prerender.Option
+	if *service != "" {
+		options = append(options, prerender.ServiceURL(*service))
+	}
+
+	app := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "request would not be prerendered", http.StatusBadGateway)
+	})
+
+	req, err := newRequest("GET", fs.Arg(0), *ua)
+	if err != nil {
+		return err
+	}
+
+	rec := httptest.NewRecorder()
+	prerender.Handler(app, options...).ServeHTTP(rec, req)
+
+	fmt.Fprintln(os.Stderr, rec.Code, http.StatusText(rec.Code))
+	names := make([]string, 0, len(rec.Header()))
+	for name := range rec.Header() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range rec.Header()[name] {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, value)
+		}
+	}
+
+	if _, err := io.Copy(os.Stdout, rec.Body); err != nil {
+		return err
+	}
+	if rec.Code >= http.StatusBadRequest {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// newRequest returns a request for rawurl as a server receives it, with the
+// scheme of rawurl in X-Forwarded-Proto.
+func newRequest(method, rawurl, ua string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = req.URL.RequestURI()
+	req.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
+	req.Header.Set("User-Agent", ua)
+	return req, nil
+}
+
+// warm recaches the pages listed in sitemaps with the prerender.io recache
+// API.
+func warm(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	concurrency := fs.Int("concurrency", 4, "number of concurrent recaches")
+	rate := fs.Float64("rate", 0, "recaches per second, 0 means no limit")
+	robots := fs.Bool("robots", false, "skip the pages disallowed by robots.txt")
+	robotsAgent := fs.String("robots-agent", "", "crawler whose robots.txt rules apply, like Googlebot (default *)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("warm: expected at least one sitemap")
+	}
+
+	w := warmer.New(prerender.NewClient(""))
+	w.Concurrency = *concurrency
+	w.Rate = *rate
+	w.Robots, w.RobotsAgent = *robots, *robotsAgent
+	w.Logger = log.New(os.Stderr, "prerenderctl: ", 0)
+
+	result, err := w.Warm(ctx, fs.Args()...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rendered %d, failed %d, skipped %d\n", result.Rendered, result.Failed, result.Skipped)
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// purge purges the cache of an app through its purge endpoint.
+func purge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "URL of the purge endpoint")
+	secret := fs.String("secret", os.Getenv("PRERENDER_PURGE_SECRET"), "shared secret of the purge endpoint")
+	all := fs.Bool("all", false, "purge the entire cache")
+	prefix := fs.String("prefix", "", "purge all URLs starting with prefix")
+	fs.Parse(args)
+
+	var doc map[string]interface{}
+	switch {
+	case *endpoint == "":
+		return fmt.Errorf("purge: missing -endpoint")
+	case *all:
+		doc = map[string]interface{}{"all": true}
+	case *prefix != "":
+		doc = map[string]interface{}{"prefix": *prefix}
+	case fs.NArg() > 0:
+		doc = map[string]interface{}{"urls": fs.Args()}
+	default:
+		return fmt.Errorf("purge: expected -all, -prefix or URLs")
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", *endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *secret != "" {
+		req.Header.Set("Authorization", "Bearer "+*secret)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("purge: %s", resp.Status)
+	}
+
+	var result struct {
+		Purged int `json:"purged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("purged %d\n", result.Purged)
+	return nil
+}
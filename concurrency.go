@@ -0,0 +1,125 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errSaturated is returned for renders which got no render slot.
+var errSaturated = errors.New("prerender: too many concurrent renders")
+
+// MaxConcurrentRenders limits the concurrent requests to the prerender
+// service made for crawlers to n. Up to queue more renders wait at most
+// timeout for a slot; renders beyond the queue or waiting longer are
+// handled according to ConcurrencyOverflow. An n of zero or less means no
+// limit.
+func MaxConcurrentRenders(n, queue int, timeout time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.renderSlots = nil
+		if n <= 0 {
+			return
+		}
+		h.renderSlots = &renderSlots{
+			slots:    make(chan struct{}, n),
+			n:        n,
+			maxQueue: queue,
+			timeout:  timeout,
+		}
+	}
+}
+
+// ConcurrencyOverflow sets the handling of renders beyond
+// MaxConcurrentRenders. The default is OverflowApp.
+// OverflowServiceUnavailable is a good alternative.
+func ConcurrencyOverflow(o Overflow) Option {
+	return func(h *Prerenderer) {
+		h.concurrencyOverflow = o
+	}
+}
+
+// saturated reports whether no render slot could be taken, in which case it
+// serves req according to the overflow handling. Otherwise the caller must
+// release the slot.
+func (h *Prerenderer) saturated(rw http.ResponseWriter, req *http.Request, key string) bool {
+	if h.renderSlots == nil || h.renderSlots.acquire(req.Context()) {
+		return false
+	}
+
+	h.serveSaturated(rw, req, key)
+	return true
+}
+
+// serveSaturated serves req, which got no render slot, according to the
+// overflow handling.
+func (h *Prerenderer) serveSaturated(rw http.ResponseWriter, req *http.Request, key string) {
+	h.load.saturate()
+	h.logReqf(req, "prerender: too many concurrent renders: %q", req.URL)
+	h.serveOverflow(rw, req, key, h.concurrencyOverflow)
+}
+
+// releaseSlot releases the render slot taken by saturated.
+func (h *Prerenderer) releaseSlot() {
+	if h.renderSlots != nil {
+		h.renderSlots.release()
+	}
+}
+
+// renderSlots is a semaphore with a bounded wait queue.
+type renderSlots struct {
+	slots    chan struct{}
+	n        int
+	maxQueue int
+	timeout  time.Duration
+
+	mtx    sync.Mutex
+	queued int
+}
+
+// acquire takes a slot, waiting in the queue when all slots are taken.
+func (s *renderSlots) acquire(ctx context.Context) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	s.mtx.Lock()
+	if s.queued >= s.maxQueue {
+		s.mtx.Unlock()
+		return false
+	}
+	s.queued++
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		s.queued--
+		s.mtx.Unlock()
+	}()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *renderSlots) release() {
+	<-s.slots
+}
+
+// queueLen returns the number of renders waiting for a slot.
+func (s *renderSlots) queueLen() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.queued
+}
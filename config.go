@@ -0,0 +1,413 @@
+package prerender
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConfigCacheTTL is the cache TTL of a Config without one, as
+// snapshots with a zero TTL expire immediately.
+const defaultConfigCacheTTL = time.Hour
+
+// Config configures a Prerenderer as a plain struct, as an alternative to
+// functional options for configuration built by external systems. It can be
+// read from a file with LoadConfig or unmarshaled directly, and is applied
+// with WithConfig. Zero fields keep the defaults.
+//
+//	service_url: http://localhost:3000/
+//	timeout: 20s
+//	bots: [twitterbot, slackbot]
+//	blacklist: ['^/admin']
+//	cache:
+//	  max_entries: 1000
+//	  ttl: 1h
+//	  path_ttls:
+//	    - {path: /news/**, ttl: 10m}
+//	hosts:
+//	  shop.example.com:
+//	    token: ...
+//	    cache:
+//	      namespace: shop
+type Config struct {
+	ServiceURL string `json:"service_url" yaml:"service_url" toml:"service_url"`
+	Token      string `json:"token" yaml:"token" toml:"token"`
+	// HostTokens sets the token per host, see TokenForHost.
+	HostTokens map[string]string `json:"host_tokens" yaml:"host_tokens" toml:"host_tokens"`
+	Username   string            `json:"username" yaml:"username" toml:"username"`
+	Password   string            `json:"password" yaml:"password" toml:"password"`
+	// TokenFile, UsernameFile and PasswordFile read the credentials from
+	// files, see ServiceTokenFile and ServiceAuthFiles.
+	TokenFile    string `json:"token_file" yaml:"token_file" toml:"token_file"`
+	UsernameFile string `json:"username_file" yaml:"username_file" toml:"username_file"`
+	PasswordFile string `json:"password_file" yaml:"password_file" toml:"password_file"`
+	// DisableEnv and EnvPrefix control the environment variables, see
+	// DisableEnv and EnvPrefix.
+	DisableEnv      bool     `json:"disable_env" yaml:"disable_env" toml:"disable_env"`
+	EnvPrefix       string   `json:"env_prefix" yaml:"env_prefix" toml:"env_prefix"`
+	Timeout         Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Bots            []string `json:"bots" yaml:"bots" toml:"bots"`
+	BotPatterns     []string `json:"bot_patterns" yaml:"bot_patterns" toml:"bot_patterns"`
+	AllowSearchBots bool     `json:"allow_search_bots" yaml:"allow_search_bots" toml:"allow_search_bots"`
+	VerifyBots      bool     `json:"verify_bots" yaml:"verify_bots" toml:"verify_bots"`
+	// ClassPolicies classifies requests with DefaultClassifier and sets
+	// the policies of the classes, like {"scraper": "block"}.
+	ClassPolicies     map[BotClass]Policy `json:"class_policies" yaml:"class_policies" toml:"class_policies"`
+	MinConfidence     float64             `json:"min_confidence" yaml:"min_confidence" toml:"min_confidence"`
+	IgnoredExtensions []string            `json:"ignored_extensions" yaml:"ignored_extensions" toml:"ignored_extensions"`
+	Methods           []string            `json:"methods" yaml:"methods" toml:"methods"`
+	Whitelist         []string            `json:"whitelist" yaml:"whitelist" toml:"whitelist"`
+	Blacklist         []string            `json:"blacklist" yaml:"blacklist" toml:"blacklist"`
+	ForwardHeaders    []string            `json:"forward_headers" yaml:"forward_headers" toml:"forward_headers"`
+	// CorrelationHeaders enables CorrelationHeaders with these headers.
+	CorrelationHeaders []string `json:"correlation_headers" yaml:"correlation_headers" toml:"correlation_headers"`
+	AcceptAware        bool     `json:"accept_aware" yaml:"accept_aware" toml:"accept_aware"`
+	// PerformanceTools is unset by default, see the PerformanceTools option.
+	PerformanceTools *bool    `json:"performance_tools" yaml:"performance_tools" toml:"performance_tools"`
+	FollowRedirects  int      `json:"follow_redirects" yaml:"follow_redirects" toml:"follow_redirects"`
+	TrustedProxies   []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	CanonicalHost    string   `json:"canonical_host" yaml:"canonical_host" toml:"canonical_host"`
+	AllowedHosts     []string `json:"allowed_hosts" yaml:"allowed_hosts" toml:"allowed_hosts"`
+	PreservePorts    bool     `json:"preserve_ports" yaml:"preserve_ports" toml:"preserve_ports"`
+	MaxResponseBytes int64    `json:"max_response_bytes" yaml:"max_response_bytes" toml:"max_response_bytes"`
+	Compress         bool     `json:"compress" yaml:"compress" toml:"compress"`
+	Locales          []string `json:"locales" yaml:"locales" toml:"locales"`
+	MobileVariants   bool     `json:"mobile_variants" yaml:"mobile_variants" toml:"mobile_variants"`
+	AsyncRender      bool     `json:"async_render" yaml:"async_render" toml:"async_render"`
+	// RenderBudget and RenderInBackground enable RenderBudget.
+	RenderBudget       Duration `json:"render_budget" yaml:"render_budget" toml:"render_budget"`
+	RenderInBackground bool     `json:"render_in_background" yaml:"render_in_background" toml:"render_in_background"`
+	// RobotsTTL enables RespectRobots.
+	RobotsTTL Duration `json:"robots_ttl" yaml:"robots_ttl" toml:"robots_ttl"`
+	// MaxRendersPerSecond and Burst enable MaxRendersPerSecond, and
+	// RateLimitOverflow is "app", "stale", "429" or "503".
+	MaxRendersPerSecond float64  `json:"max_renders_per_second" yaml:"max_renders_per_second" toml:"max_renders_per_second"`
+	Burst               int      `json:"burst" yaml:"burst" toml:"burst"`
+	RateLimitOverflow   Overflow `json:"rate_limit_overflow" yaml:"rate_limit_overflow" toml:"rate_limit_overflow"`
+	// MaxConcurrentRenders, RenderQueue and RenderQueueTimeout enable
+	// MaxConcurrentRenders, and ConcurrencyOverflow is like
+	// RateLimitOverflow.
+	MaxConcurrentRenders int      `json:"max_concurrent_renders" yaml:"max_concurrent_renders" toml:"max_concurrent_renders"`
+	RenderQueue          int      `json:"render_queue" yaml:"render_queue" toml:"render_queue"`
+	RenderQueueTimeout   Duration `json:"render_queue_timeout" yaml:"render_queue_timeout" toml:"render_queue_timeout"`
+	ConcurrencyOverflow  Overflow `json:"concurrency_overflow" yaml:"concurrency_overflow" toml:"concurrency_overflow"`
+	// ServiceAddrs pins the addresses of the service, see ResolveService.
+	ServiceAddrs []string `json:"service_addrs" yaml:"service_addrs" toml:"service_addrs"`
+	DNSCacheTTL  Duration `json:"dns_cache_ttl" yaml:"dns_cache_ttl" toml:"dns_cache_ttl"`
+	// ServiceProxy overrides the proxy of the environment, see ServiceProxy.
+	ServiceProxy *string `json:"service_proxy" yaml:"service_proxy" toml:"service_proxy"`
+	// HTTP2 enables HTTP2, or H2C if Cleartext is set.
+	HTTP2 struct {
+		Enabled    bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+		Cleartext  bool `json:"cleartext" yaml:"cleartext" toml:"cleartext"`
+		MaxStreams int  `json:"max_streams" yaml:"max_streams" toml:"max_streams"`
+	} `json:"http2" yaml:"http2" toml:"http2"`
+	// TLS configures TLS with the prerender service.
+	TLS struct {
+		CertFile           string `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+		KeyFile            string `json:"key_file" yaml:"key_file" toml:"key_file"`
+		CAFile             string `json:"ca_file" yaml:"ca_file" toml:"ca_file"`
+		InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+	} `json:"tls" yaml:"tls" toml:"tls"`
+	Cache struct {
+		// Store is the cache store. It can't be read from a file; set
+		// MaxEntries to use an in-memory cache instead.
+		Store Store `json:"-" yaml:"-" toml:"-"`
+		// MaxEntries enables an in-memory cache of that size.
+		MaxEntries int `json:"max_entries" yaml:"max_entries" toml:"max_entries"`
+		// TTL is the time pages are cached, by default an hour.
+		TTL       Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+		Namespace string   `json:"namespace" yaml:"namespace" toml:"namespace"`
+		// BypassSecret enables CacheBypass.
+		BypassSecret string `json:"bypass_secret" yaml:"bypass_secret" toml:"bypass_secret"`
+		StaleOnError bool   `json:"stale_on_error" yaml:"stale_on_error" toml:"stale_on_error"`
+		// PathTTLs are applied in order, see PathTTL.
+		PathTTLs []struct {
+			Path string   `json:"path" yaml:"path" toml:"path"`
+			TTL  Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+		} `json:"path_ttls" yaml:"path_ttls" toml:"path_ttls"`
+	} `json:"cache" yaml:"cache" toml:"cache"`
+	// RenderControls are applied in order, see PathRenderControl; without
+	// Path they apply to all other pages, see RenderControl.
+	RenderControls []struct {
+		Path   string                 `json:"path" yaml:"path" toml:"path"`
+		Header map[string]string      `json:"header" yaml:"header" toml:"header"`
+		Params map[string]interface{} `json:"params" yaml:"params" toml:"params"`
+	} `json:"render_controls" yaml:"render_controls" toml:"render_controls"`
+	// Hosts overrides the configuration per host, see ForHost.
+	Hosts map[string]*Config `json:"hosts" yaml:"hosts" toml:"hosts"`
+}
+
+// A Duration is a time.Duration written as a string like "1m30s" in
+// configuration files.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// configFormats unmarshal configuration files by extension.
+var (
+	configFormatsMtx sync.RWMutex
+	configFormats    = map[string]func(data []byte, v interface{}) error{
+		".json": json.Unmarshal,
+	}
+)
+
+// configPackages are the packages registering the formats of
+// configuration files, for error messages.
+var configPackages = map[string]string{
+	".yaml": "github.com/fd/prerender/configyaml",
+	".yml":  "github.com/fd/prerender/configyaml",
+	".toml": "github.com/fd/prerender/configtoml",
+}
+
+// RegisterConfigFormat makes LoadConfig read files with the extension ext,
+// like ".yaml", with unmarshal. The configyaml and configtoml packages
+// register YAML and TOML when imported.
+func RegisterConfigFormat(ext string, unmarshal func(data []byte, v interface{}) error) {
+	configFormatsMtx.Lock()
+	defer configFormatsMtx.Unlock()
+
+	configFormats[strings.ToLower(ext)] = unmarshal
+}
+
+// LoadConfig reads a configuration file in the format of its extension.
+// JSON is always supported; import configyaml or configtoml for YAML or
+// TOML:
+//
+//	import _ "github.com/fd/prerender/configyaml"
+func LoadConfig(path string) (*Config, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	configFormatsMtx.RLock()
+	unmarshal := configFormats[ext]
+	configFormatsMtx.RUnlock()
+	if unmarshal == nil {
+		if pkg := configPackages[ext]; pkg != "" {
+			return nil, fmt.Errorf("prerender: unsupported config format %q, import %s", ext, pkg)
+		}
+		return nil, fmt.Errorf("prerender: unsupported config format %q", ext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("prerender: invalid config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// WithConfig applies the configuration cfg.
+func WithConfig(cfg *Config) Option {
+	options := cfg.Options()
+	return func(h *Prerenderer) {
+		for _, option := range options {
+			option(h)
+		}
+	}
+}
+
+// Options returns the options described by the configuration.
+func (cfg *Config) Options() []Option {
+	var options []Option
+
+	if cfg.ServiceURL != "" {
+		options = append(options, ServiceURL(cfg.ServiceURL))
+	}
+	if cfg.Token != "" {
+		options = append(options, ServiceToken(cfg.Token))
+	}
+	if cfg.HostTokens != nil {
+		options = append(options, TokenForHost(cfg.HostTokens))
+	}
+	if cfg.Username != "" || cfg.Password != "" {
+		options = append(options, ServiceAuth(cfg.Username, cfg.Password))
+	}
+	if cfg.TokenFile != "" {
+		options = append(options, ServiceTokenFile(cfg.TokenFile))
+	}
+	if cfg.UsernameFile != "" || cfg.PasswordFile != "" {
+		options = append(options, ServiceAuthFiles(cfg.UsernameFile, cfg.PasswordFile))
+	}
+	if cfg.DisableEnv {
+		options = append(options, DisableEnv())
+	}
+	if cfg.EnvPrefix != "" {
+		options = append(options, EnvPrefix(cfg.EnvPrefix))
+	}
+	if cfg.Timeout != 0 {
+		options = append(options, Timeout(time.Duration(cfg.Timeout)))
+	}
+	if cfg.Bots != nil {
+		options = append(options, Bots(cfg.Bots))
+	}
+	if cfg.BotPatterns != nil {
+		options = append(options, BotPatterns(cfg.BotPatterns...))
+	}
+	if cfg.AllowSearchBots {
+		options = append(options, AllowSearchBots())
+	}
+	if cfg.VerifyBots {
+		options = append(options, VerifyBots())
+	}
+	if cfg.ClassPolicies != nil {
+		options = append(options, Classify(DefaultClassifier, cfg.MinConfidence))
+		for class, p := range cfg.ClassPolicies {
+			options = append(options, ClassPolicy(class, p))
+		}
+	}
+	if cfg.IgnoredExtensions != nil {
+		options = append(options, IgnoredExtensions(cfg.IgnoredExtensions))
+	}
+	if cfg.Methods != nil {
+		options = append(options, Methods(cfg.Methods...))
+	}
+	if cfg.Whitelist != nil {
+		options = append(options, Whitelist(cfg.Whitelist...))
+	}
+	if cfg.Blacklist != nil {
+		options = append(options, Blacklist(cfg.Blacklist...))
+	}
+	if cfg.ForwardHeaders != nil {
+		options = append(options, ForwardRequestHeaders(cfg.ForwardHeaders...))
+	}
+	if cfg.CorrelationHeaders != nil {
+		options = append(options, CorrelationHeaders(cfg.CorrelationHeaders...))
+	}
+	if cfg.AcceptAware {
+		options = append(options, AcceptAware())
+	}
+	if cfg.PerformanceTools != nil {
+		options = append(options, PerformanceTools(*cfg.PerformanceTools))
+	}
+	if cfg.FollowRedirects != 0 {
+		options = append(options, FollowRedirects(cfg.FollowRedirects))
+	}
+	if cfg.TrustedProxies != nil {
+		options = append(options, TrustedProxies(cfg.TrustedProxies...))
+	}
+	if cfg.CanonicalHost != "" {
+		options = append(options, CanonicalHost(cfg.CanonicalHost))
+	}
+	if cfg.AllowedHosts != nil {
+		options = append(options, AllowedHosts(cfg.AllowedHosts...))
+	}
+	if cfg.PreservePorts {
+		options = append(options, PreservePorts())
+	}
+	if cfg.MaxResponseBytes != 0 {
+		options = append(options, MaxResponseBytes(cfg.MaxResponseBytes))
+	}
+	if cfg.Compress {
+		options = append(options, Compress())
+	}
+	if cfg.Locales != nil {
+		options = append(options, VaryByLocale(cfg.Locales...))
+	}
+	if cfg.MobileVariants {
+		options = append(options, MobileVariants())
+	}
+	if cfg.AsyncRender {
+		options = append(options, AsyncRender())
+	}
+	if cfg.RenderBudget != 0 {
+		options = append(options, RenderBudget(time.Duration(cfg.RenderBudget), cfg.RenderInBackground))
+	}
+	if cfg.RobotsTTL != 0 {
+		options = append(options, RespectRobots(time.Duration(cfg.RobotsTTL)))
+	}
+	if cfg.MaxRendersPerSecond != 0 {
+		options = append(options, MaxRendersPerSecond(cfg.MaxRendersPerSecond, cfg.Burst), RateLimitOverflow(cfg.RateLimitOverflow))
+	}
+	if cfg.MaxConcurrentRenders != 0 {
+		options = append(options, MaxConcurrentRenders(cfg.MaxConcurrentRenders, cfg.RenderQueue, time.Duration(cfg.RenderQueueTimeout)), ConcurrencyOverflow(cfg.ConcurrencyOverflow))
+	}
+	if cfg.ServiceAddrs != nil {
+		options = append(options, ResolveService(cfg.ServiceAddrs...))
+	}
+	if cfg.DNSCacheTTL != 0 {
+		options = append(options, CacheDNS(time.Duration(cfg.DNSCacheTTL)))
+	}
+	if cfg.ServiceProxy != nil {
+		options = append(options, ServiceProxy(*cfg.ServiceProxy))
+	}
+	switch {
+	case cfg.HTTP2.Cleartext:
+		options = append(options, H2C(cfg.HTTP2.MaxStreams))
+	case cfg.HTTP2.Enabled:
+		options = append(options, HTTP2(cfg.HTTP2.MaxStreams))
+	}
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		options = append(options, ClientCertificateFiles(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+	if cfg.TLS.CAFile != "" {
+		options = append(options, RootCAFile(cfg.TLS.CAFile))
+	}
+	if cfg.TLS.InsecureSkipVerify {
+		options = append(options, InsecureSkipVerify())
+	}
+
+	ttl := time.Duration(cfg.Cache.TTL)
+	if ttl == 0 {
+		ttl = defaultConfigCacheTTL
+	}
+	switch store := cfg.Cache.Store; {
+	case store != nil:
+		options = append(options, Cache(store, ttl))
+	case cfg.Cache.MaxEntries > 0:
+		options = append(options, Cache(NewMemoryStore(cfg.Cache.MaxEntries), ttl))
+	}
+	if cfg.Cache.Namespace != "" {
+		options = append(options, CacheNamespace(cfg.Cache.Namespace))
+	}
+	if cfg.Cache.StaleOnError {
+		options = append(options, ServeStaleOnError())
+	}
+	if cfg.Cache.BypassSecret != "" {
+		options = append(options, CacheBypass(cfg.Cache.BypassSecret))
+	}
+	for _, rule := range cfg.Cache.PathTTLs {
+		options = append(options, PathTTL(rule.Path, time.Duration(rule.TTL)))
+	}
+	for _, rule := range cfg.RenderControls {
+		c := RenderControls{Header: make(http.Header, len(rule.Header)), Params: rule.Params}
+		for name, value := range rule.Header {
+			c.Header.Set(name, value)
+		}
+		if rule.Path == "" {
+			options = append(options, RenderControl(c))
+		} else {
+			options = append(options, PathRenderControl(rule.Path, c))
+		}
+	}
+	for host, hostCfg := range cfg.Hosts {
+		// An empty entry, like "example.com:" in YAML, overrides nothing.
+		if hostCfg == nil {
+			continue
+		}
+		options = append(options, ForHost(host, hostCfg.Options()...))
+	}
+
+	return options
+}
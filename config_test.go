@@ -0,0 +1,146 @@
+package prerender
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "json",
+			file: "prerender.json",
+			data: `{"service_url": "http://localhost:3000/", "timeout": "1m30s", "bots": ["twitterbot"], "cache": {"max_entries": 10, "ttl": "1h"}}`,
+		},
+		{
+			name:    "invalid duration",
+			file:    "prerender.json",
+			data:    `{"timeout": "soon"}`,
+			wantErr: "invalid config",
+		},
+		{
+			name:    "unregistered format",
+			file:    "prerender.yaml",
+			data:    "timeout: 1s",
+			wantErr: "import github.com/fd/prerender/configyaml",
+		},
+		{
+			name:    "unknown format",
+			file:    "prerender.ini",
+			wantErr: `unsupported config format ".ini"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ServiceURL != "http://localhost:3000/" || time.Duration(cfg.Timeout) != 90*time.Second {
+				t.Errorf("ServiceURL, Timeout = %q, %v", cfg.ServiceURL, time.Duration(cfg.Timeout))
+			}
+			if len(cfg.Bots) != 1 || cfg.Bots[0] != "twitterbot" {
+				t.Errorf("Bots = %v", cfg.Bots)
+			}
+			if cfg.Cache.MaxEntries != 10 || time.Duration(cfg.Cache.TTL) != time.Hour {
+				t.Errorf("Cache = %+v", cfg.Cache)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadConfig error = %v, want not exist", err)
+	}
+}
+
+func TestWithConfigHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prerender.json")
+	data := `{"hosts": {"shop.example.com": {"token": "t"}, "empty.example.com": null}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hosts["shop.example.com"].Token != "t" {
+		t.Errorf("Hosts = %v", cfg.Hosts)
+	}
+	// Null hosts are skipped.
+	if _, err := New(nil, WithConfig(cfg), DisableEnv()); err != nil {
+		t.Errorf("New: %v", err)
+	}
+}
+
+func TestWithConfigCacheTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  Duration
+		want time.Duration
+	}{
+		{"default", 0, time.Hour},
+		{"set", Duration(time.Minute), time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			cfg.Cache.MaxEntries = 10
+			cfg.Cache.TTL = tt.ttl
+			h, err := New(nil, WithConfig(&cfg), DisableEnv())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.cacheTTL != tt.want {
+				t.Errorf("cache TTL = %s, want %s", h.cacheTTL, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterConfigFormat(t *testing.T) {
+	RegisterConfigFormat(".TEST", func(data []byte, v interface{}) error {
+		v.(*Config).ServiceURL = string(data)
+		return nil
+	})
+	defer func() {
+		configFormatsMtx.Lock()
+		delete(configFormats, ".test")
+		configFormatsMtx.Unlock()
+	}()
+
+	path := filepath.Join(t.TempDir(), "prerender.Test")
+	if err := os.WriteFile(path, []byte("http://render/"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServiceURL != "http://render/" {
+		t.Errorf("ServiceURL = %q", cfg.ServiceURL)
+	}
+}
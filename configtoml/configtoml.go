@@ -0,0 +1,14 @@
+// Package configtoml makes prerender.LoadConfig read TOML files (.toml) when
+// it is imported.
+//
+//	import _ "github.com/fd/prerender/configtoml"
+package configtoml
+
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/fd/prerender"
+)
+
+func init() {
+	prerender.RegisterConfigFormat(".toml", toml.Unmarshal)
+}
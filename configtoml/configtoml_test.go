@@ -0,0 +1,50 @@
+package configtoml
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fd/prerender"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr bool
+	}{
+		{"toml", "prerender.toml", "service_url = \"http://localhost:3000/\"\ntimeout = \"1m30s\"\n\n[cache]\nmax_entries = 10\nttl = \"1h\"\n", false},
+		{"invalid", "prerender.toml", "cache = [", true},
+		{"invalid duration", "prerender.toml", "timeout = \"soon\"\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := prerender.LoadConfig(path)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid config") {
+					t.Fatalf("LoadConfig error = %v, want invalid config", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ServiceURL != "http://localhost:3000/" || time.Duration(cfg.Timeout) != 90*time.Second {
+				t.Errorf("ServiceURL, Timeout = %q, %v", cfg.ServiceURL, time.Duration(cfg.Timeout))
+			}
+			if cfg.Cache.MaxEntries != 10 || time.Duration(cfg.Cache.TTL) != time.Hour {
+				t.Errorf("Cache = %+v", cfg.Cache)
+			}
+		})
+	}
+}
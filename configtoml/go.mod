@@ -0,0 +1,10 @@
+module github.com/fd/prerender/configtoml
+
+go 1.24
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fd/prerender v0.0.0
+)
+
+replace github.com/fd/prerender => ../
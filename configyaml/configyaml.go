@@ -0,0 +1,15 @@
+// Package configyaml makes prerender.LoadConfig read YAML files (.yaml and
+// .yml) when it is imported.
+//
+//	import _ "github.com/fd/prerender/configyaml"
+package configyaml
+
+import (
+	"github.com/fd/prerender"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	prerender.RegisterConfigFormat(".yaml", yaml.Unmarshal)
+	prerender.RegisterConfigFormat(".yml", yaml.Unmarshal)
+}
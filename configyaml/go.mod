@@ -0,0 +1,10 @@
+module github.com/fd/prerender/configyaml
+
+go 1.24
+
+require (
+	github.com/fd/prerender v0.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace github.com/fd/prerender => ../
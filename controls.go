@@ -0,0 +1,58 @@
+package prerender
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// RenderControls tell the render service how to render pages, like waiting
+// for a selector or a delay, for pages which need longer to hydrate before
+// their snapshot is valid. The controls are specific to the service.
+type RenderControls struct {
+	// Header is added to the render requests, like the X-Prerender-*
+	// headers of prerender.io.
+	Header http.Header
+	// Params are added to the body of JSONBackend requests, over its
+	// options, like "waitFor": "#app" or "timeout": 30000. Custom backends
+	// read them from RenderRequest.Controls.
+	Params map[string]interface{}
+}
+
+// RenderControl sends c with the render requests of all pages without
+// PathRenderControl.
+func RenderControl(c RenderControls) Option {
+	return func(h *Prerenderer) {
+		h.renderControls = &c
+	}
+}
+
+// PathRenderControl sends c with the render requests of the pages with
+// paths matching pattern, instead of the RenderControl. Patterns are like
+// those of PathTTL, and the first matching pattern applies.
+func PathRenderControl(pattern string, c RenderControls) Option {
+	re := compileGlob(pattern)
+	return func(h *Prerenderer) {
+		h.pathControls = append(h.pathControls, pathControls{glob: pattern, re: re, controls: &c})
+	}
+}
+
+type pathControls struct {
+	glob     string
+	re       *regexp.Regexp
+	controls *RenderControls
+}
+
+// controls returns the render controls of the page at u, or nil.
+func (h *Prerenderer) controls(u *url.URL) *RenderControls {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	for _, rule := range h.pathControls {
+		if rule.re.MatchString(p) {
+			return rule.controls
+		}
+	}
+	return h.renderControls
+}
@@ -0,0 +1,60 @@
+package prerender
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultCorrelationHeaders are the headers of CorrelationHeaders without
+// names.
+var defaultCorrelationHeaders = []string{"X-Request-Id", "Traceparent", "Tracestate"}
+
+// CorrelationHeaders forwards the correlation headers names of crawler
+// requests to the prerender service and adds them to the log lines and
+// RenderEvents of the requests, so failed renders can be traced across the
+// app and the render farm. It defaults to X-Request-Id, traceparent and
+// tracestate.
+func CorrelationHeaders(names ...string) Option {
+	if len(names) == 0 {
+		names = defaultCorrelationHeaders
+	}
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+
+	return func(h *Prerenderer) {
+		h.correlationHeaders = canonical
+	}
+}
+
+// correlation returns the correlation headers of req, or nil if it has
+// none.
+func (h *Prerenderer) correlation(req *http.Request) http.Header {
+	var header http.Header
+	for _, name := range h.correlationHeaders {
+		if values := req.Header.Values(name); len(values) > 0 {
+			if header == nil {
+				header = make(http.Header, len(h.correlationHeaders))
+			}
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// logReqf is like logf, followed by the correlation headers of req like
+// X-Request-Id=abc.
+func (h *Prerenderer) logReqf(req *http.Request, format string, args ...interface{}) {
+	var ids []string
+	for _, name := range h.correlationHeaders {
+		if v := req.Header.Get(name); v != "" {
+			ids = append(ids, name+"="+v)
+		}
+	}
+	if len(ids) > 0 {
+		format += " %s"
+		args = append(args, strings.Join(ids, " "))
+	}
+	h.logf(format, args...)
+}
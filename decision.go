@@ -0,0 +1,82 @@
+package prerender
+
+import (
+	"net/http"
+	"time"
+)
+
+// A Reason explains why a request is or isn't prerendered.
+type Reason string
+
+// Reasons to prerender a request.
+const (
+	ReasonEscapedFragment Reason = "escaped_fragment"
+	ReasonBot             Reason = "bot"
+	ReasonBufferbot       Reason = "bufferbot"
+	ReasonPerformanceTool Reason = "performance_tool"
+	ReasonClass           Reason = "class"
+)
+
+// Reasons to pass a request through to the app. ReasonPerformanceTool is
+// also used when PerformanceTools(false) excludes a performance tool, and
+// ReasonClass when the class of the request has PolicyPass. Requests
+// with ReasonBlocked are refused instead. ReasonRenderer is the reason of
+// the requests of the prerender service itself, and ReasonRobots of the
+// requests disallowed by robots.txt with RespectRobots.
+const (
+	ReasonNoUserAgent      Reason = "no_user_agent"
+	ReasonMethod           Reason = "method"
+	ReasonStreaming        Reason = "streaming"
+	ReasonNotHTML          Reason = "not_html"
+	ReasonNotBot           Reason = "not_bot"
+	ReasonUnverified       Reason = "unverified"
+	ReasonIgnoredExtension Reason = "ignored_extension"
+	ReasonWhitelist        Reason = "whitelist"
+	ReasonBlacklist        Reason = "blacklist"
+	ReasonFlags            Reason = "flags"
+	ReasonSampledOut       Reason = "sampled_out"
+	ReasonHost             Reason = "host"
+	ReasonBlocked          Reason = "blocked"
+	ReasonRenderer         Reason = "renderer"
+	ReasonRobots           Reason = "robots"
+)
+
+// DryRun never calls the prerender service. Requests which would have been
+// prerendered are served by the app and reported, with the reason, to the
+// log and OnRender. It estimates the prerender costs and reveals false
+// positives (like monitoring agents matching the bot list) before
+// prerendering is enabled.
+func DryRun() Option {
+	return func(h *Prerenderer) {
+		h.dryRun = true
+	}
+}
+
+// dryRunRender serves req, which would have been prerendered, with the app.
+func (h *Prerenderer) dryRunRender(rw http.ResponseWriter, req *http.Request, reason Reason) {
+	u, err := h.requestURL(req)
+	if err != nil {
+		h.sub.ServeHTTP(rw, req)
+		return
+	}
+
+	start := time.Now()
+	w := &responseWriter{ResponseWriter: rw}
+	h.sub.ServeHTTP(w.wrap(), req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	h.logReqf(req, "prerender dry run: would prerender %s (%s, %q)", u, reason, req.UserAgent())
+	h.emitRender(RenderEvent{
+		URL:         u,
+		UserAgent:   req.UserAgent(),
+		Credits:     1,
+		Duration:    time.Since(start),
+		Reason:      reason,
+		DryRun:      true,
+		AppStatus:   w.status,
+		AppBytes:    w.written,
+		Correlation: h.correlation(req),
+	})
+}
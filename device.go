@@ -0,0 +1,40 @@
+package prerender
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mobileUserAgents are the User-Agent substrings of mobile crawlers, like
+// Googlebot Smartphone and the mobile Bingbot.
+var mobileUserAgents = []string{
+	"mobile",
+	"android",
+	"iphone",
+}
+
+// MobileVariants renders pages for mobile crawlers separately from desktop
+// crawlers and caches both variants. Mobile renders pass a device hint to
+// the renderer: the default backend appends Rendertron's ?mobile to the
+// service URL and JSONBackend adds "mobile": true to the body.
+func MobileVariants() Option {
+	return func(h *Prerenderer) {
+		h.mobileVariants = true
+	}
+}
+
+// isMobile reports whether req is rendered as the mobile variant. A nil req
+// selects the desktop variant.
+func (h *Prerenderer) isMobile(req *http.Request) bool {
+	if !h.mobileVariants || req == nil {
+		return false
+	}
+
+	ua := strings.ToLower(req.UserAgent())
+	for _, name := range mobileUserAgents {
+		if strings.Contains(ua, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+// Package diskstore stores prerendered pages in a directory, so they
+// survive restarts of single-node deployments.
+//
+// Snapshots are written atomically to files sharded in subdirectories by
+// the hash of their key. Each file starts with the key and the expiry time
+// of the snapshot, followed by the snapshot as JSON.
+package diskstore
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fd/prerender"
+)
+
+// Store is a prerender.Store backed by a directory. It evicts the least
+// recently used snapshots when the files exceed MaxBytes.
+type Store struct {
+	dir string
+
+	// MaxBytes limits the total size of the files. Zero means no limit.
+	MaxBytes int64
+	// Retention is the time snapshots are kept after they expire, so they
+	// can still be served within a MinRenderInterval. Older snapshots are
+	// deleted when they are read.
+	Retention time.Duration
+
+	mtx     sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// New returns a Store using dir, which is created when it doesn't exist.
+// The snapshots already in dir are indexed, least recently modified first.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:     dir,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := s.index(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// index adds the snapshot files in the directory to the LRU list.
+func (s *Store) index() error {
+	type file struct {
+		key   string
+		size  int64
+		mtime time.Time
+	}
+
+	var files []file
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".snap" {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, _, err := readHeader(path)
+		if err != nil {
+			// Skip files which are being written or were corrupted.
+			return nil
+		}
+		files = append(files, file{key: key, size: info.Size(), mtime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Push the most recently modified files last, to the front.
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	for _, f := range files {
+		s.touch(f.key, f.size)
+	}
+	return nil
+}
+
+// Get implements prerender.Store.
+func (s *Store) Get(ctx context.Context, key string) (*prerender.Snapshot, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, prerender.ErrNotCached
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	storedKey, expires, err := parseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if storedKey != key {
+		// A hash collision.
+		return nil, prerender.ErrNotCached
+	}
+	if !expires.IsZero() && time.Now().After(expires.Add(s.Retention)) {
+		f.Close()
+		s.Delete(ctx, key)
+		return nil, prerender.ErrNotCached
+	}
+
+	var snap prerender.Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	if elem, found := s.entries[key]; found {
+		s.lru.MoveToFront(elem)
+	}
+	s.mtx.Unlock()
+
+	return &snap, nil
+}
+
+// Put implements prerender.Store.
+func (s *Store) Put(ctx context.Context, key string, snap *prerender.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	var expires int64
+	if !snap.ExpiresAt.IsZero() {
+		expires = snap.ExpiresAt.Unix()
+	}
+	header := fmt.Sprintf("%s\n%d\n", strconv.Quote(key), expires)
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory and rename it, so
+	// readers never see a partial snapshot.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.WriteString(tmp, header); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.touch(key, int64(len(header)+len(data)))
+	return s.evict()
+}
+
+// Delete implements prerender.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.remove(key)
+}
+
+// Keys implements prerender.Store.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var keys []string
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Size returns the total size of the snapshot files.
+func (s *Store) Size() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.size
+}
+
+// path returns the file of key, like dir/ab/cd/abcd....snap.
+func (s *Store) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(s.dir, name[:2], name[2:4], name+".snap")
+}
+
+// touch records the file of key as most recently used. s.mtx must be held.
+func (s *Store) touch(key string, size int64) {
+	if elem, found := s.entries[key]; found {
+		e := elem.Value.(*entry)
+		s.size += size - e.size
+		e.size = size
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	s.entries[key] = s.lru.PushFront(&entry{key: key, size: size})
+	s.size += size
+}
+
+// evict removes the least recently used files until the total size is
+// within MaxBytes. s.mtx must be held.
+func (s *Store) evict() error {
+	for s.MaxBytes > 0 && s.size > s.MaxBytes && s.lru.Len() > 0 {
+		if err := s.remove(s.lru.Back().Value.(*entry).key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the file of key. s.mtx must be held.
+func (s *Store) remove(key string) error {
+	if elem, found := s.entries[key]; found {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+		s.size -= elem.Value.(*entry).size
+	}
+
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func readHeader(path string) (string, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer f.Close()
+
+	return parseHeader(bufio.NewReader(f))
+}
+
+// parseHeader reads the quoted key and the expiry time (Unix seconds, 0
+// for none) which start a snapshot file.
+func parseHeader(r *bufio.Reader) (string, time.Time, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	key, err := strconv.Unquote(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires, err := strconv.ParseInt(strings.TrimSuffix(line, "\n"), 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if expires == 0 {
+		return key, time.Time{}, nil
+	}
+	return key, time.Unix(expires, 0), nil
+}
@@ -0,0 +1,131 @@
+package prerender
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ResolveService connects to the prerender service at the IP addresses addrs
+// instead of resolving its host name, for services without public DNS. The
+// addresses are tried in order.
+func ResolveService(addrs ...string) Option {
+	var err error
+	for _, addr := range addrs {
+		if net.ParseIP(addr) == nil {
+			err = fmt.Errorf("invalid service address %q", addr)
+			break
+		}
+	}
+
+	return func(h *Prerenderer) {
+		h.serviceAddrs = addrs
+		h.optionError(err)
+	}
+}
+
+// CacheDNS caches the addresses of the prerender service for ttl. Expired
+// addresses are used as long as lookups fail, so DNS outages don't take down
+// bot traffic.
+func CacheDNS(ttl time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.dnsTTL = ttl
+	}
+}
+
+// resolver resolves the host of the prerender service when dialing it.
+type resolver struct {
+	host   string
+	static []string
+	ttl    time.Duration
+	logf   func(format string, args ...interface{})
+
+	mtx     sync.Mutex
+	addrs   []string
+	expires time.Time
+}
+
+// newResolver returns the resolver of the service host, or nil if the
+// addresses aren't pinned or cached.
+func (h *Prerenderer) newResolver() *resolver {
+	if h.serviceAddrs == nil && h.dnsTTL <= 0 {
+		return nil
+	}
+	u, err := url.Parse(h.serviceURL())
+	if err != nil {
+		return nil
+	}
+	return &resolver{host: u.Hostname(), static: h.serviceAddrs, ttl: h.dnsTTL, logf: h.logf}
+}
+
+// resolve returns the addresses of the service host.
+func (r *resolver) resolve(ctx context.Context) ([]string, error) {
+	if r.static != nil {
+		return r.static, nil
+	}
+
+	r.mtx.Lock()
+	addrs, expires := r.addrs, r.expires
+	r.mtx.Unlock()
+	if addrs != nil && time.Now().Before(expires) {
+		return addrs, nil
+	}
+
+	fresh, err := net.DefaultResolver.LookupHost(ctx, r.host)
+	if err != nil {
+		if addrs != nil {
+			r.logf("prerender warning: using expired addresses of %s: %s", r.host, err)
+			return addrs, nil
+		}
+		return nil, err
+	}
+
+	r.mtx.Lock()
+	r.addrs, r.expires = fresh, time.Now().Add(r.ttl)
+	r.mtx.Unlock()
+	return fresh, nil
+}
+
+// wrap returns a dial function connecting to the resolved addresses of the
+// service host with dial, and to other hosts, like proxies, unchanged.
+func (r *resolver) wrap(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host != r.host {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := r.resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var firstErr error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no addresses for %s", r.host)
+		}
+		return nil, firstErr
+	}
+}
+
+// resolveTransport makes t dial the addresses of r.
+func (r *resolver) resolveTransport(t *http.Transport) {
+	t.DialContext = r.wrap(t.DialContext)
+}
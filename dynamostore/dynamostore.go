@@ -0,0 +1,128 @@
+// Package dynamostore stores prerendered pages in an Amazon DynamoDB table.
+//
+// The table must have a string partition key named "key". Enable DynamoDB
+// TTL on the "expires" attribute to delete old snapshots.
+package dynamostore
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/fd/prerender"
+)
+
+// API is the part of the DynamoDB client used by Store.
+type API interface {
+	GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, in *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, in *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Store is a prerender.Store backed by a DynamoDB table.
+type Store struct {
+	client API
+	table  string
+
+	// Retention is the time snapshots are kept after they expire, so they
+	// can still be served within a MinRenderInterval.
+	Retention time.Duration
+}
+
+// New returns a Store using the DynamoDB table.
+func New(client API, table string) *Store {
+	return &Store{client: client, table: table}
+}
+
+// Get implements prerender.Store.
+func (s *Store) Get(ctx context.Context, key string) (*prerender.Snapshot, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key:       itemKey(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := out.Item["snapshot"].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, prerender.ErrNotCached
+	}
+
+	var snap prerender.Snapshot
+	if err := json.Unmarshal(data.Value, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Put implements prerender.Store.
+func (s *Store) Put(ctx context.Context, key string, snap *prerender.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	item := itemKey(key)
+	item["snapshot"] = &types.AttributeValueMemberB{Value: data}
+	if !snap.ExpiresAt.IsZero() {
+		expires := snap.ExpiresAt.Add(s.Retention).Unix()
+		item["expires"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expires, 10)}
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	})
+	return err
+}
+
+// Delete implements prerender.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       itemKey(key),
+	})
+	return err
+}
+
+// Keys implements prerender.Store. It scans the entire table.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	in := &dynamodb.ScanInput{
+		TableName:                aws.String(s.table),
+		ProjectionExpression:     aws.String("#k"),
+		ExpressionAttributeNames: map[string]string{"#k": "key"},
+	}
+	if prefix != "" {
+		in.FilterExpression = aws.String("begins_with(#k, :prefix)")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: prefix},
+		}
+	}
+
+	var keys []string
+	for p := dynamodb.NewScanPaginator(s.client, in); p.HasMorePages(); {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			if key, ok := item["key"].(*types.AttributeValueMemberS); ok {
+				keys = append(keys, key.Value)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func itemKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key": &types.AttributeValueMemberS{Value: key},
+	}
+}
@@ -0,0 +1,110 @@
+package prerender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// minCompressSize is the smallest body worth compressing.
+const minCompressSize = 1024
+
+// Compress enables gzip compression of prerendered pages for clients which
+// advertise support for it. Pages are compressed once when they are
+// rendered, and cached compressed. The prerenderbrotli package adds Brotli.
+func Compress() Option {
+	return func(h *Prerenderer) {
+		h.compress = true
+	}
+}
+
+// ContentEncoding encodes prerendered pages with the content coding named
+// coding, like "br", for clients which advertise support for it, in
+// preference to gzip and to the codings given before. Pages are encoded
+// once when they are rendered, and cached encoded in Snapshot.Encoded. It
+// requires Compress.
+func ContentEncoding(coding string, encode func(body []byte) ([]byte, error)) Option {
+	coding = strings.ToLower(coding)
+	return func(h *Prerenderer) {
+		h.encodings = append([]contentEncoding{{coding, encode}}, h.encodings...)
+	}
+}
+
+type contentEncoding struct {
+	coding string
+	encode func(body []byte) ([]byte, error)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of req allows
+// the given content coding.
+func acceptsEncoding(req *http.Request, coding string) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if (name == coding || name == "*") && !isZeroQuality(params[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeSnapshot adds the compressed variants of the body of snap, so they
+// are cached with it.
+func (h *Prerenderer) encodeSnapshot(snap *Snapshot) {
+	if !h.compress || len(snap.Body) < minCompressSize {
+		return
+	}
+
+	if snap.Gzip == nil {
+		snap.Gzip = gzipBody(snap.Body, gzip.DefaultCompression)
+	}
+
+	for _, e := range h.encodings {
+		body, err := e.encode(snap.Body)
+		if err != nil {
+			h.logf("prerender: %s encoding error: %s", e.coding, err)
+			continue
+		}
+		if snap.Encoded == nil {
+			snap.Encoded = make(map[string][]byte, len(h.encodings))
+		}
+		snap.Encoded[e.coding] = body
+	}
+}
+
+// encode returns the body to send to the client and its content coding.
+func (h *Prerenderer) encode(req *http.Request, snap *Snapshot) ([]byte, string) {
+	for _, e := range h.encodings {
+		if body := snap.Encoded[e.coding]; body != nil && acceptsEncoding(req, e.coding) {
+			return body, e.coding
+		}
+	}
+
+	if snap.Gzip != nil && acceptsEncoding(req, "gzip") {
+		return snap.Gzip, "gzip"
+	}
+
+	// Snapshots cached before Compress was enabled are compressed on the
+	// fly, as cheaply as possible.
+	if h.compress && len(snap.Body) >= minCompressSize && acceptsEncoding(req, "gzip") {
+		if body := gzipBody(snap.Body, gzip.BestSpeed); body != nil {
+			return body, "gzip"
+		}
+	}
+
+	return snap.Body, ""
+}
+
+// gzipBody returns body compressed with gzip at level, or nil on errors.
+func gzipBody(body []byte, level int) []byte {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil
+	}
+	if _, err := w.Write(body); err != nil || w.Close() != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
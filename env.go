@@ -0,0 +1,52 @@
+package prerender
+
+import "os"
+
+// defaultEnvPrefix is the prefix of the environment variables read by
+// default, like PRERENDER_SERVICE_URL.
+const defaultEnvPrefix = "PRERENDER_"
+
+// DisableEnv ignores the environment variables, so only the options
+// configure the handler, for example in tests.
+func DisableEnv() Option {
+	return func(h *Prerenderer) {
+		h.envDisabled = true
+	}
+}
+
+// EnvPrefix reads the environment variables with prefix instead of
+// PRERENDER_, like MYAPP_SERVICE_URL and MYAPP_TOKEN for MYAPP_, so
+// handlers in the same process can be configured separately.
+func EnvPrefix(prefix string) Option {
+	return func(h *Prerenderer) {
+		h.envPrefix = prefix
+	}
+}
+
+// readEnv applies the environment variables starting with prefix:
+//
+//	SERVICE_URL
+//	TOKEN, TOKEN_FILE
+//	USERNAME, PASSWORD
+//	USERNAME_FILE, PASSWORD_FILE
+func (h *Prerenderer) readEnv(prefix string) {
+	if v := os.Getenv(prefix + "SERVICE_URL"); v != "" {
+		ServiceURL(v)(h)
+	}
+
+	if v := os.Getenv(prefix + "TOKEN"); v != "" {
+		ServiceToken(v)(h)
+	}
+
+	if v := os.Getenv(prefix + "TOKEN_FILE"); v != "" {
+		ServiceTokenFile(v)(h)
+	}
+
+	if u, p := os.Getenv(prefix+"USERNAME"), os.Getenv(prefix+"PASSWORD"); u != "" || p != "" {
+		ServiceAuth(u, p)(h)
+	}
+
+	if u, p := os.Getenv(prefix+"USERNAME_FILE"), os.Getenv(prefix+"PASSWORD_FILE"); u != "" || p != "" {
+		ServiceAuthFiles(u, p)(h)
+	}
+}
@@ -0,0 +1,63 @@
+package prerender
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DisableETags disables the ETag header and If-None-Match handling for
+// prerendered pages.
+func DisableETags() Option {
+	return func(h *Prerenderer) {
+		h.disableETags = true
+	}
+}
+
+// computeETag returns the strong entity tag of body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// encodedETag returns the entity tag of the representation of a page with
+// the given content coding.
+func encodedETag(etag, coding string) string {
+	if coding == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// etagMatches reports whether the If-None-Match header of req matches etag.
+func etagMatches(req *http.Request, etag string) bool {
+	for _, tag := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates the conditional headers of req against the ETag and
+// Last-Modified response headers. If-Modified-Since is ignored when
+// If-None-Match is present.
+func notModified(req *http.Request, header http.Header) bool {
+	if req.Header.Get("If-None-Match") != "" {
+		etag := header.Get("ETag")
+		return etag != "" && etagMatches(req, etag)
+	}
+
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
@@ -0,0 +1,100 @@
+package prerender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestComputeETag(t *testing.T) {
+	a, b := computeETag([]byte("a")), computeETag([]byte("b"))
+	if a == b {
+		t.Errorf("computeETag of different bodies = %s for both", a)
+	}
+	if a != computeETag([]byte("a")) {
+		t.Errorf("computeETag isn't deterministic")
+	}
+	if len(a) != 34 || a[0] != '"' || a[len(a)-1] != '"' {
+		t.Errorf("computeETag = %s, want a quoted 32 digit hash", a)
+	}
+}
+
+func TestEncodedETag(t *testing.T) {
+	tests := []struct {
+		etag, coding, want string
+	}{
+		{`"abc"`, "", `"abc"`},
+		{`"abc"`, "gzip", `"abc-gzip"`},
+		{`"abc"`, "br", `"abc-br"`},
+	}
+
+	for _, tt := range tests {
+		if got := encodedETag(tt.etag, tt.coding); got != tt.want {
+			t.Errorf("encodedETag(%s, %q) = %s, want %s", tt.etag, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"none", "", false},
+		{"etag", `"abc"`, true},
+		{"weak etag", `W/"abc"`, true},
+		{"etag list", `"x", "abc"`, true},
+		{"any", "*", true},
+		{"other etag", `"x"`, false},
+		{"encoded etag", `"abc-gzip"`, false},
+		{"unquoted", "abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if got := etagMatches(req, `"abc"`); got != tt.want {
+				t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const lastModified = "Mon, 01 Jan 2024 10:00:00 GMT"
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   bool
+	}{
+		{"unconditional", nil, false},
+		{"etag", map[string]string{"If-None-Match": `"abc"`}, true},
+		{"modified since", map[string]string{"If-Modified-Since": "Mon, 01 Jan 2024 09:59:59 GMT"}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified}, true},
+		{"not modified since later", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 00:00:00 GMT"}, true},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{
+			"etag takes precedence",
+			map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": lastModified},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			header := http.Header{"Etag": {`"abc"`}, "Last-Modified": {lastModified}}
+			if got := notModified(req, header); got != tt.want {
+				t.Errorf("notModified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
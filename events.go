@@ -0,0 +1,81 @@
+package prerender
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const x_PRERENDER_COST = "X-Prerender-Cost"
+
+// RenderEvent describes the cost of serving a single prerendered response.
+type RenderEvent struct {
+	// URL is the original URL requested by the crawler (see OriginalURL).
+	URL *url.URL
+	// UserAgent is the User-Agent of the crawler.
+	UserAgent string
+	// CacheHit is true when the response was served without rendering.
+	CacheHit bool
+	// Credits is the number of render credits spent: 0 for cache hits and 1
+	// for renders.
+	Credits int
+	// Bytes is the number of body bytes written to the crawler.
+	Bytes int64
+	// Duration is the time spent serving the response.
+	Duration time.Duration
+	// Status is the status code of the prerendered page.
+	Status int
+	// Reason is the reason the request was prerendered.
+	Reason Reason
+	// Class is the class of the request when Classify is used.
+	Class BotClass
+	// Correlation are the correlation headers of the request, see
+	// CorrelationHeaders.
+	Correlation http.Header
+
+	// Shadow is true for the renders of ShadowMode, which are not served.
+	// Bytes and Duration then describe the render, and AppStatus and
+	// AppBytes the response of the app served instead.
+	Shadow    bool
+	AppStatus int
+	AppBytes  int64
+
+	// Async is true for the background renders of AsyncRender, which are
+	// not served.
+	Async bool
+
+	// DryRun is true for the requests which DryRun served with the app
+	// instead of prerendering. Credits are the credits which would have
+	// been spent on a render.
+	DryRun bool
+}
+
+// OnRender registers fn to be called after every prerendered response.
+func OnRender(fn func(RenderEvent)) Option {
+	return func(h *Prerenderer) {
+		h.onRender = fn
+	}
+}
+
+// CostHeader adds an X-Prerender-Cost debug header to prerendered responses.
+func CostHeader() Option {
+	return func(h *Prerenderer) {
+		h.costHeader = true
+	}
+}
+
+func (h *Prerenderer) emitRender(e RenderEvent) {
+	if h.onRender != nil {
+		h.onRender(e)
+	}
+}
+
+// costHeader formats the X-Prerender-Cost header. bytes is omitted when it
+// is unknown (negative).
+func costHeader(credits int, bytes int64) string {
+	if bytes < 0 {
+		return fmt.Sprintf("credits=%d", credits)
+	}
+	return fmt.Sprintf("credits=%d, bytes=%d", credits, bytes)
+}
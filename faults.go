@@ -0,0 +1,76 @@
+package prerender
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errInjectedFault is returned for calls failed by InjectFaults.
+var errInjectedFault = errors.New("prerender: injected fault")
+
+// Faults describes artificial failures of the prerender service. Rates are
+// fractions (0 to 1) of the calls to the service.
+type Faults struct {
+	// Latency is added to LatencyRate of the calls.
+	Latency     time.Duration
+	LatencyRate float64
+	// ErrorRate of the calls fail with an error.
+	ErrorRate float64
+	// MalformedRate of the calls return a corrupt response.
+	MalformedRate float64
+}
+
+// InjectFaults injects artificial latency, errors and malformed responses
+// into calls to the prerender service, to verify fallback and alerting
+// configurations before a real outage. It is meant for testing only.
+func InjectFaults(f Faults) Option {
+	return func(h *Prerenderer) {
+		h.faults = &f
+	}
+}
+
+type faultTransport struct {
+	next   http.RoundTripper
+	faults Faults
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rand.Float64() < t.faults.LatencyRate {
+		select {
+		case <-time.After(t.faults.Latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rand.Float64() < t.faults.ErrorRate {
+		return nil, errInjectedFault
+	}
+
+	if rand.Float64() < t.faults.MalformedRate {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/html"}},
+			Body: io.NopCloser(io.MultiReader(
+				strings.NewReader("<html><head><title>"),
+				errorReader{io.ErrUnexpectedEOF},
+			)),
+			ContentLength: -1,
+			Request:       req,
+		}, nil
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
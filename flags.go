@@ -0,0 +1,184 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flags are dynamic switches consulted on every request, allowing
+// prerendering to be toggled and rolled out from a feature-flag system.
+type Flags struct {
+	// Enabled turns prerendering on or off.
+	Enabled bool `json:"enabled"`
+	// SampleRate is the fraction (0 to 1) of eligible requests that are
+	// prerendered.
+	SampleRate float64 `json:"sample_rate"`
+	// Paths maps path prefixes to a rollout fraction (0 to 1). The longest
+	// matching prefix overrides SampleRate.
+	Paths map[string]float64 `json:"paths"`
+}
+
+// DefaultFlags enables prerendering for all eligible requests.
+var DefaultFlags = Flags{Enabled: true, SampleRate: 1}
+
+// A FlagSource provides the current Flags.
+type FlagSource interface {
+	Flags() Flags
+}
+
+// FeatureFlags makes prerendering subject to the flags provided by src.
+func FeatureFlags(src FlagSource) Option {
+	return func(h *Prerenderer) {
+		h.flags = src
+	}
+}
+
+// SampleRate only prerenders a fraction (0 to 1) of the eligible requests,
+// for gradual rollouts and cost control. Sampling is consistent per URL, so
+// a page is either always or never prerendered.
+func SampleRate(rate float64) Option {
+	return func(h *Prerenderer) {
+		h.sampleRate = &rate
+	}
+}
+
+// sampleAllows reports whether req is within the SampleRate.
+func (h *Prerenderer) sampleAllows(req *http.Request) bool {
+	if h.sampleRate == nil {
+		return true
+	}
+
+	key := req.URL.RequestURI()
+	if u, err := h.requestURL(req); err == nil {
+		key = u.String()
+	}
+	return sampled(key, *h.sampleRate)
+}
+
+func (h *Prerenderer) flagsAllow(req *http.Request) bool {
+	if h.flags == nil {
+		return true
+	}
+
+	flags := h.flags.Flags()
+	if !flags.Enabled {
+		return false
+	}
+
+	var (
+		rate    = flags.SampleRate
+		longest = -1
+	)
+	for prefix, r := range flags.Paths {
+		if strings.HasPrefix(req.URL.Path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+
+	return sampled(req.URL.RequestURI(), rate)
+}
+
+// sampled consistently selects a fraction rate of all keys.
+func sampled(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	f := fnv.New32a()
+	f.Write([]byte(key))
+	return float64(f.Sum32()) < rate*math.MaxUint32
+}
+
+// defaultPollInterval is the interval of pollers without one.
+const defaultPollInterval = time.Minute
+
+// FlagPoller is a FlagSource which periodically fetches its Flags as a JSON
+// document from a URL. Until the first successful fetch it provides
+// DefaultFlags.
+type FlagPoller struct {
+	URL string
+	// Interval is the time between fetches, by default a minute.
+	Interval time.Duration
+	Client   *http.Client
+
+	mtx   sync.RWMutex
+	flags Flags
+}
+
+// NewFlagPoller returns a FlagPoller for url. Call Run to start polling.
+func NewFlagPoller(url string, interval time.Duration) *FlagPoller {
+	return &FlagPoller{URL: url, Interval: interval, flags: DefaultFlags}
+}
+
+// Flags returns the most recently fetched flags.
+func (p *FlagPoller) Flags() Flags {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return p.flags
+}
+
+// Run polls the flags until ctx is done. Failed fetches keep the previous
+// flags and are reported to logf when it is not nil.
+func (p *FlagPoller) Run(ctx context.Context, logf func(format string, args ...interface{})) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Fetch(ctx); err != nil && logf != nil {
+			logf("prerender flags error: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetch fetches the flags once.
+func (p *FlagPoller) Fetch(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	flags := DefaultFlags
+	if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	p.flags = flags
+	p.mtx.Unlock()
+	return nil
+}
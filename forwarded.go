@@ -0,0 +1,122 @@
+package prerender
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies only honors the forwarded scheme and host headers
+// (Forwarded, X-Forwarded-Proto, X-Forwarded-Host and Cf-Visitor) of
+// requests coming from the given proxies, so clients can't spoof them to change the rendered URL.
+// Proxies are given as CIDRs or single IP addresses. By default all
+// forwarded headers are honored.
+//
+// Invalid proxies are reported by New; Handler panics on them.
+func TrustedProxies(cidrs ...string) Option {
+	var err error
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, perr := net.ParseCIDR(cidr)
+		if perr != nil {
+			err = fmt.Errorf("invalid trusted proxy: %w", perr)
+			break
+		}
+		nets = append(nets, n)
+	}
+
+	return func(h *Prerenderer) {
+		h.trustedProxies = nets
+		h.optionError(err)
+	}
+}
+
+// trustsProxy reports whether the forwarded headers of req are honored.
+func (h *Prerenderer) trustsProxy(req *http.Request) bool {
+	if h.trustedProxies == nil {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return h.isTrustedProxy(ip)
+}
+
+func (h *Prerenderer) isTrustedProxy(ip net.IP) bool {
+	for _, n := range h.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client of req. X-Forwarded-For is
+// only honored behind TrustedProxies, as clients could spoof it otherwise;
+// the rightmost untrusted address is the client.
+func (h *Prerenderer) clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	if h.trustedProxies == nil || ip == nil || !h.trustsProxy(req) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !h.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseForwarded returns the proto and host parameters of the first element
+// of an RFC 7239 Forwarded header (the one added by the proxy closest to
+// the client).
+//
+//	Forwarded: for=192.0.2.60;proto=https;host=example.com, for=198.51.100.17
+func parseForwarded(header string) (proto, host string) {
+	first, _, _ := strings.Cut(header, ",")
+	for _, pair := range strings.Split(first, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = strings.ReplaceAll(value[1:len(value)-1], `\`, "")
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "proto":
+			proto = strings.ToLower(value)
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
@@ -0,0 +1,113 @@
+// Package gcsstore stores prerendered pages in a Google Cloud Storage
+// bucket, shared by all instances of the app.
+//
+// The Custom-Time of objects is their expiry time, so a lifecycle rule with
+// the daysSinceCustomTime condition deletes old snapshots.
+package gcsstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/fd/prerender"
+)
+
+// Store is a prerender.Store backed by a GCS bucket.
+type Store struct {
+	bucket *storage.BucketHandle
+
+	// Layout names the objects. It defaults to prerender.PrefixLayout("").
+	Layout prerender.ObjectLayout
+	// Retention is the time snapshots are kept after they expire, so they
+	// can still be served within a MinRenderInterval. It is added to the
+	// Custom-Time of the objects.
+	Retention time.Duration
+}
+
+// New returns a Store using the bucket.
+func New(bucket *storage.BucketHandle) *Store {
+	return &Store{bucket: bucket}
+}
+
+func (s *Store) layout() prerender.ObjectLayout {
+	if s.Layout == nil {
+		return prerender.PrefixLayout("")
+	}
+	return s.Layout
+}
+
+// Get implements prerender.Store.
+func (s *Store) Get(ctx context.Context, key string) (*prerender.Snapshot, error) {
+	r, err := s.bucket.Object(s.layout().Name(key)).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, prerender.ErrNotCached
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var snap prerender.Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Put implements prerender.Store.
+func (s *Store) Put(ctx context.Context, key string, snap *prerender.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.bucket.Object(s.layout().Name(key)).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if !snap.ExpiresAt.IsZero() {
+		w.CustomTime = snap.ExpiresAt.Add(s.Retention)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		// Canceling the context aborts the upload.
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete implements prerender.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(s.layout().Name(key)).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Keys implements prerender.Store.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.layout().Name(prefix)})
+
+	var keys []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := s.layout().Key(attrs.Name); ok {
+			keys = append(keys, key)
+		}
+	}
+}
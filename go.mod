@@ -0,0 +1,3 @@
+module github.com/fd/prerender
+
+go 1.24
@@ -2,51 +2,183 @@
 package prerender
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
 	"net/url"
-	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type handler struct {
+// Prerenderer is an http.Handler that serves prerendered pages to bots and
+// passes all other requests to the app.
+type Prerenderer struct {
 	sub                 http.Handler
 	botUserAgents       []string
-	ignoredExtension    []string
+	botMatcher          BotMatcher
+	searchBots          bool
+	verifier            *botVerifier
+	botRanges           []botRanges
+	classifier          Classifier
+	minConfidence       float64
+	classPolicies       map[BotClass]Policy
+	renderRate          float64
+	renderLimiter       *tokenBucket
+	overflow            Overflow
+	renderSlots         *renderSlots
+	concurrencyOverflow Overflow
+	botSource           BotSource
+	ignoredExtensions   map[string]struct{}
+	whitelist           []*regexp.Regexp
+	blacklist           []*regexp.Regexp
+	methods             []string
+	forwardHeaders      []string
 	prerenderServiceURL string
-	prerenderToken      string
-	prerenderUsername   string
-	prerenderPassword   string
+	backend             Backend
+	tokenProvider       TokenProvider
+	usernameProvider    TokenProvider
+	passwordProvider    TokenProvider
+	acceptAware         bool
+	trustedProxies      []*net.IPNet
+	performanceTools    *bool
+	flags               FlagSource
+	sampleRate          *float64
+	onRender            func(RenderEvent)
+	shadow              chan struct{}
+	dryRun              bool
+	costHeader          bool
+	maxRedirects        int
+	preservePorts       bool
+	canonicalHost       string
+	allowedHosts        []string
+	normalize           bool
+	dropParams          []string
+	translateFragment   bool
+	maxResponseBytes    int64
+	truncateOversized   bool
+	rendererMarker      string
+	compress            bool
+	encodings           []contentEncoding
+	cache               Store
+	cacheTTL            time.Duration
+	pathTTLs            []pathTTL
+	renderControls      *RenderControls
+	pathControls        []pathControls
+	cacheNamespace      string
+	cacheKeyFunc        func(*http.Request) string
+	bypassSecret        string
+	staleOnError        bool
+	transformers        []Transformer
+	minRenderInterval   time.Duration
+	locales             []string
+	mobileVariants      bool
+	disableETags        bool
+	maxHeaderCount      int
+	maxHeaderBytes      int
+	allowHeaders        []string
+	denyHeaders         []string
+	faults              *Faults
+	transportOptions    []func(*http.Transport)
+	customTransport     http.RoundTripper
+	insecureSkipVerify  bool
+	serviceAddrs        []string
+	dnsTTL              time.Duration
+	http2               *http2Config
+	envDisabled         bool
+	envPrefix           string
+	correlationHeaders  []string
+	onDecision          func(Decision)
+	decisionLog         func(Decision)
+	renderBudget        time.Duration
+	budgetBackground    bool
+	async               *asyncRenders
+	robots              *robotsCache
+	errs                []error
+	tenant              bool
+	tenantOptions       map[string][]Option
+	tenants             map[string]*Prerenderer
+	client              *http.Client
+	proxy               *httputil.ReverseProxy
 	log                 *log.Logger
+	load                *loadTracker
 }
 
-type Option func(*handler)
+type Option func(*Prerenderer)
 
 // Handler returns a new prerender handler. app must be your HTTP app.
-func Handler(app http.Handler, options ...Option) http.Handler {
-	if app == nil {
-		app = http.DefaultServeMux
+// It panics when an option can't be parsed (like an invalid trusted proxy);
+// use New to validate the configuration instead.
+func Handler(app http.Handler, options ...Option) *Prerenderer {
+	h := newPrerenderer(app, options, false)
+	if len(h.errs) > 0 {
+		panic("prerender: " + errors.Join(h.errs...).Error())
+	}
+	for host, t := range h.tenants {
+		if len(t.errs) > 0 {
+			panic("prerender: host " + host + ": " + errors.Join(t.errs...).Error())
+		}
+	}
+	return h
+}
+
+// New returns a new prerender handler like Handler, but validates the
+// options first and returns an error describing all invalid options.
+func New(app http.Handler, options ...Option) (*Prerenderer, error) {
+	h := newPrerenderer(app, options, false)
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Middleware returns the prerender handler as standard middleware, for
+// middleware chains like chi or alice. It panics like Handler.
+func Middleware(options ...Option) func(http.Handler) http.Handler {
+	return func(app http.Handler) http.Handler {
+		return Handler(app, options...)
 	}
+}
 
-	h := &handler{sub: app}
+// defaultPrerenderer returns a handler with the default options.
+func defaultPrerenderer(app http.Handler, tenant bool) *Prerenderer {
+	h := &Prerenderer{sub: app, tenant: tenant, envPrefix: defaultEnvPrefix, load: new(loadTracker)}
+	h.client = &http.Client{CheckRedirect: h.checkRedirect}
 
-	// Defaults
 	Bots(crawlerUserAgents)(h)
 	IgnoredExtensions(extensionsToIgnore)(h)
+	Methods("GET")(h)
 	ServiceURL(prerenderServiceURL)(h)
 
-	if v := os.Getenv("PRERENDER_SERVICE_URL"); v != "" {
-		ServiceURL(v)(h)
+	return h
+}
+
+func newPrerenderer(app http.Handler, options []Option, tenant bool) *Prerenderer {
+	if app == nil {
+		app = http.DefaultServeMux
 	}
 
-	if v := os.Getenv("PRERENDER_TOKEN"); v != "" {
-		ServiceToken(v)(h)
+	// The environment is read before the options, so they override it. The
+	// options are applied to a probe first to know how to read it.
+	probe := defaultPrerenderer(app, tenant)
+	for _, option := range options {
+		option(probe)
 	}
 
-	if u, p := os.Getenv("PRERENDER_USERNAME"), os.Getenv("PRERENDER_PASSWORD"); u != "" || p != "" {
-		ServiceAuth(u, p)(h)
+	h := defaultPrerenderer(app, tenant)
+	if !probe.envDisabled {
+		h.readEnv(probe.envPrefix)
 	}
 
 	// User provided
@@ -54,102 +186,487 @@ func Handler(app http.Handler, options ...Option) http.Handler {
 		option(h)
 	}
 
+	h.configureClient()
+
+	if len(h.tenantOptions) > 0 {
+		h.tenants = make(map[string]*Prerenderer, len(h.tenantOptions))
+		for host, hostOptions := range h.tenantOptions {
+			h.tenants[host] = h.newTenant(hostOptions)
+		}
+	}
+
 	return h
 }
 
+// configureClient sets the transport of the client to the prerender service
+// and the reverse proxy rendering pages, once the options are applied.
+func (h *Prerenderer) configureClient() {
+	h.client.Transport = h.transport()
+	if h.insecureSkipVerify {
+		h.logf("prerender warning: not verifying the certificate of the prerender service")
+	}
+	if h.faults != nil {
+		h.client.Transport = &faultTransport{next: h.client.Transport, faults: *h.faults}
+	}
+	h.proxy = h.newProxy()
+}
+
 // Bots replaces the default list of bot User-Agents with a custom list.
+// User-Agents are matched as case-insensitive substrings; see BotPatterns
+// for stricter matching.
 func Bots(userAgents []string) Option {
-	return func(h *handler) {
-		h.botUserAgents = userAgents
+	m := substringMatcher(userAgents)
+	return func(h *Prerenderer) {
+		h.botUserAgents, h.botMatcher = userAgents, m
+	}
+}
+
+// AllowSearchBots also prerenders pages for search engine crawlers
+// (Googlebot, Bingbot, Yahoo, DuckDuckBot and Yandex), in addition to the
+// bot list. They are excluded by default to avoid cloaking penalties; use
+// this option for sites which no longer rely on _escaped_fragment_.
+func AllowSearchBots() Option {
+	return func(h *Prerenderer) {
+		h.searchBots = true
 	}
 }
 
 // IgnoredExtensions replaces the default list of ignored extentions with a custom list.
+// Extensions are matched case-insensitively against the end of the path and
+// may be compound (like .tar.gz).
 func IgnoredExtensions(exts []string) Option {
-	return func(h *handler) {
-		h.ignoredExtension = exts
+	return func(h *Prerenderer) {
+		h.ignoredExtensions = make(map[string]struct{}, len(exts))
+		for _, ext := range exts {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			h.ignoredExtensions[ext] = struct{}{}
+		}
+	}
+}
+
+// Methods replaces the default list of request methods (GET) which are
+// eligible for prerendering.
+func Methods(methods ...string) Option {
+	return func(h *Prerenderer) {
+		h.methods = methods
+	}
+}
+
+// ForwardRequestHeaders forwards the named request headers (like
+// Accept-Language or Cookie) to the prerender service. Only User-Agent is
+// forwarded by default. Forwarded headers are not part of the cache key; use
+// VaryByLocale to cache per language.
+func ForwardRequestHeaders(names ...string) Option {
+	return func(h *Prerenderer) {
+		h.forwardHeaders = names
 	}
 }
 
-// ServiceURL sets the prerender service url.
+// ServiceURL sets the prerender service url. A URL like
+// unix:///var/run/prerender.sock connects to a service listening on a unix
+// socket, like a sidecar.
 func ServiceURL(url string) Option {
-	return func(h *handler) {
+	return func(h *Prerenderer) {
 		h.prerenderServiceURL = url
 	}
 }
 
-// ServiceToken sets the prerender service token.
+// ServiceToken sets the prerender service token, see also
+// ServiceTokenProvider.
 func ServiceToken(token string) Option {
-	return func(h *handler) {
-		h.prerenderToken = token
+	return func(h *Prerenderer) {
+		h.tokenProvider = nil
+		if token != "" {
+			h.tokenProvider = StaticToken(token)
+		}
 	}
 }
 
 // ServiceAuth sets the prerender username and password.
 func ServiceAuth(username, password string) Option {
-	return func(h *handler) {
-		h.prerenderUsername, h.prerenderPassword = username, password
+	return func(h *Prerenderer) {
+		h.usernameProvider, h.passwordProvider = nil, nil
+		if username != "" {
+			h.usernameProvider = StaticToken(username)
+		}
+		if password != "" {
+			h.passwordProvider = StaticToken(password)
+		}
+	}
+}
+
+// AcceptAware only prerenders requests whose Accept header allows HTML.
+// Requests explicitly asking for other media types (like application/json
+// or images) are passed through to the app.
+func AcceptAware() Option {
+	return func(h *Prerenderer) {
+		h.acceptAware = true
+	}
+}
+
+// PerformanceTools decides whether performance measurement tools (like
+// Lighthouse and PageSpeed Insights) receive prerendered pages or the real
+// app, regardless of the bot list. Measuring the wrong variant skews Core
+// Web Vitals monitoring.
+func PerformanceTools(prerender bool) Option {
+	return func(h *Prerenderer) {
+		h.performanceTools = &prerender
+	}
+}
+
+// FollowRedirects makes the handler follow up to n redirects returned by
+// the prerender service instead of passing them on to the client: the
+// target of the redirect is rendered by the service in turn. Redirects to
+// other hosts are always passed on.
+func FollowRedirects(n int) Option {
+	return func(h *Prerenderer) {
+		h.maxRedirects = n
+	}
+}
+
+// CanonicalHost pins the host of the rendered URLs, ignoring the Host and
+// forwarded host headers of the request. Use it when the Host header is an
+// internal address of a load balancer.
+func CanonicalHost(host string) Option {
+	return func(h *Prerenderer) {
+		h.canonicalHost = host
+	}
+}
+
+// AllowedHosts restricts prerendering to requests for the given hosts, so a
+// spoofed Host header can't make the prerender service fetch (and cache)
+// arbitrary sites. A leading "*." matches any subdomain. Requests for other
+// hosts are passed to the app. Combine it with CanonicalHost to rewrite the
+// host instead.
+func AllowedHosts(hosts ...string) Option {
+	return func(h *Prerenderer) {
+		h.allowedHosts = hosts
+	}
+}
+
+// PreservePorts keeps non-standard ports of the requested host in the
+// render URL. Standard ports (80 for http and 443 for https) are always
+// removed.
+func PreservePorts() Option {
+	return func(h *Prerenderer) {
+		h.preservePorts = true
+	}
+}
+
+// MaxResponseBytes limits the size of the page returned by the prerender
+// service, once decoded. Larger pages are rejected unless TruncateOversized is used.
+func MaxResponseBytes(n int64) Option {
+	return func(h *Prerenderer) {
+		h.maxResponseBytes = n
+	}
+}
+
+// TruncateOversized truncates pages larger than MaxResponseBytes (with a
+// logged warning) instead of rejecting them.
+func TruncateOversized() Option {
+	return func(h *Prerenderer) {
+		h.truncateOversized = true
+	}
+}
+
+// Whitelist restricts prerendering to requests whose path and query match
+// one of the regular expressions.
+func Whitelist(patterns ...string) Option {
+	res, err := compilePatterns("whitelist", patterns)
+	return func(h *Prerenderer) {
+		h.whitelist = res
+		h.optionError(err)
+	}
+}
+
+// Blacklist excludes requests from prerendering when their path and query,
+// or their Referer, match one of the regular expressions.
+func Blacklist(patterns ...string) Option {
+	res, err := compilePatterns("blacklist", patterns)
+	return func(h *Prerenderer) {
+		h.blacklist = res
+		h.optionError(err)
+	}
+}
+
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return res, fmt.Errorf("invalid %s pattern: %w", name, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Timeout limits the time of a request to the prerender service, including
+// redirects and reading the page.
+func Timeout(d time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.client.Timeout = d
 	}
 }
 
 // Logger sets a logger.
 func Logger(logger *log.Logger) Option {
-	return func(h *handler) {
+	return func(h *Prerenderer) {
 		h.log = logger
 	}
 }
 
+type (
+	handledKey     struct{}
+	originalURLKey struct{}
+)
+
+// OriginalURL returns the URL requested by the client, as reconstructed by
+// the handler (scheme and host from trusted forwarded headers, normalized
+// host and port), from the context of a request passing through the
+// handler. It returns nil when the URL could not be reconstructed.
+func OriginalURL(ctx context.Context) *url.URL {
+	u, _ := ctx.Value(originalURLKey{}).(*url.URL)
+	if u == nil {
+		return nil
+	}
+	c := *u
+	return &c
+}
+
+// requestURL returns the original URL of req.
+func (h *Prerenderer) requestURL(req *http.Request) (*url.URL, error) {
+	if u := OriginalURL(req.Context()); u != nil {
+		return u, nil
+	}
+	return h.originalURL(req)
+}
+
 // ServeHTTP serves the HTTP.
-func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if !h.shouldShowPrerenderedPage(req) {
+func (h *Prerenderer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// When the handler is accidentally applied twice in a chain the inner
+	// instance only passes requests through.
+	if req.Context().Value(handledKey{}) != nil {
 		h.sub.ServeHTTP(rw, req)
 		return
 	}
 
-	h.getPrerenderedPage(rw, req)
+	if t := h.tenantFor(req); t != nil {
+		t.ServeHTTP(rw, req)
+		return
+	}
+
+	var marked bool
+	req, marked = h.stripRendererMarker(req)
+	req, _ = h.stripBypass(req)
+
+	ctx := context.WithValue(req.Context(), handledKey{}, h)
+	if u, err := h.originalURL(req); err == nil {
+		ctx = context.WithValue(ctx, originalURLKey{}, u)
+	}
+	if marked {
+		req = req.WithContext(ctx)
+		h.emitDecision(req, Decision{Reason: ReasonRenderer})
+		h.sub.ServeHTTP(rw, req)
+		return
+	}
+	req = req.WithContext(h.withVerdict(ctx, req))
+
+	d := h.decide(req)
+	h.emitDecision(req, d)
+	reason := d.Reason
+	if reason == ReasonBlocked {
+		http.Error(rw, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !d.Prerender {
+		h.sub.ServeHTTP(rw, req)
+		return
+	}
+
+	if h.dryRun {
+		h.dryRunRender(rw, req, reason)
+		return
+	}
+
+	if h.shadow != nil {
+		h.shadowRender(rw, req, reason)
+		return
+	}
+
+	h.getPrerenderedPage(rw, req, reason)
+}
+
+// ShouldPrerender reports whether req would be served a prerendered page.
+func (h *Prerenderer) ShouldPrerender(req *http.Request) bool {
+	if t := h.tenantFor(req); t != nil {
+		return t.ShouldPrerender(req)
+	}
+
+	req, marked := h.stripRendererMarker(req)
+	if marked {
+		return false
+	}
+	return h.shouldShowPrerenderedPage(req)
+}
+
+func (h *Prerenderer) shouldShowPrerenderedPage(req *http.Request) bool {
+	return h.decide(req).Prerender
 }
 
-func (h *handler) shouldShowPrerenderedPage(req *http.Request) bool {
+// decide returns whether req must be prerendered and why.
+func (h *Prerenderer) decide(req *http.Request) Decision {
 	const (
 		X_BUFFERBOT      = "X-Bufferbot"
 		ESCAPED_FRAGMENT = "_escaped_fragment_"
 	)
 
 	var (
-		userAgent                   = req.UserAgent()
-		bufferAgent                 = req.Header.Get(X_BUFFERBOT)
-		isRequestingPrerenderedPage = false
+		userAgent   = req.UserAgent()
+		bufferAgent = req.Header.Get(X_BUFFERBOT)
+		reason      Reason
+		detail      string
 	)
 
+	pass := func(reason Reason, detail string) Decision {
+		return Decision{Reason: reason, Detail: detail}
+	}
+
+	verdict, policy := h.classify(req)
+	switch policy {
+	case PolicyBlock:
+		return pass(ReasonBlocked, string(verdict.Class))
+	case PolicyPass:
+		return pass(ReasonClass, string(verdict.Class))
+	}
+
 	if userAgent == "" {
-		return false
+		return pass(ReasonNoUserAgent, "")
 	}
-	if req.Method != "GET" {
-		return false
+	if !h.isEligibleMethod(req.Method) {
+		return pass(ReasonMethod, req.Method)
+	}
+	if isStreaming(req) {
+		return pass(ReasonStreaming, "")
+	}
+	if h.acceptAware && !acceptsHTML(req.Header.Get("Accept")) {
+		return pass(ReasonNotHTML, "")
 	}
 
 	if q, f := req.URL.Query()[ESCAPED_FRAGMENT]; f && len(q) > 0 {
-		isRequestingPrerenderedPage = true
+		reason = ReasonEscapedFragment
+	}
+
+	if reason == "" && (policy == PolicyRender || policy == PolicyRenderCache) {
+		reason, detail = ReasonClass, string(verdict.Class)
+	}
+
+	if reason == "" {
+		if name, ok := h.matchBot(userAgent); ok {
+			reason, detail = ReasonBot, name
+		}
+	}
+
+	if reason == "" && bufferAgent != "" {
+		reason = ReasonBufferbot
 	}
 
-	if h.isBot(userAgent) {
-		isRequestingPrerenderedPage = true
+	if h.performanceTools != nil && isPerformanceTool(userAgent) {
+		if !*h.performanceTools {
+			return pass(ReasonPerformanceTool, "")
+		}
+		if reason == "" {
+			reason = ReasonPerformanceTool
+		}
 	}
 
-	if bufferAgent != "" {
-		isRequestingPrerenderedPage = true
+	if ext := h.ignoredExtension(req.URL.Path); ext != "" {
+		return pass(ReasonIgnoredExtension, ext)
 	}
 
-	if h.containsIgnoredExtension(req.URL.Path) {
+	if h.whitelist != nil && !matchAny(h.whitelist, req.URL.RequestURI()) {
+		return pass(ReasonWhitelist, "")
+	}
+
+	if h.blacklist != nil {
+		re := matching(h.blacklist, req.URL.RequestURI())
+		if re == nil {
+			re = matching(h.blacklist, req.Referer())
+		}
+		if re != nil {
+			return pass(ReasonBlacklist, re.String())
+		}
+	}
+
+	if reason == "" {
+		return pass(ReasonNotBot, "")
+	}
+
+	if !h.flagsAllow(req) {
+		return pass(ReasonFlags, "")
+	}
+
+	if !h.sampleAllows(req) {
+		return pass(ReasonSampledOut, "")
+	}
+
+	if !h.isAllowedHost(req) {
+		return pass(ReasonHost, "")
+	}
+
+	if !h.verified(req) {
+		return pass(ReasonUnverified, "")
+	}
+
+	// robots.txt is only requested for allowed hosts.
+	if h.robots != nil {
+		if ok, pattern := h.robotsAllowed(req); !ok {
+			return pass(ReasonRobots, pattern)
+		}
+	}
+
+	return Decision{Prerender: true, Reason: reason, Detail: detail}
+}
+
+func (h *Prerenderer) isAllowedHost(req *http.Request) bool {
+	if h.allowedHosts == nil {
+		return true
+	}
+
+	u := OriginalURL(req.Context())
+	if u == nil {
 		return false
 	}
+	return h.allowsHost(u.Hostname())
+}
+
+// allowsHost reports whether AllowedHosts allows rendering pages of host.
+func (h *Prerenderer) allowsHost(host string) bool {
+	if h.allowedHosts == nil {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, allowed := range h.allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return true
+		}
+		if suffix, found := strings.CutPrefix(allowed, "*"); found && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
 
-	return isRequestingPrerenderedPage
+	h.logf("prerender: host %q is not allowed", host)
+	return false
 }
 
-func (h *handler) isBot(ua string) bool {
+func isPerformanceTool(ua string) bool {
 	ua = strings.ToLower(ua)
-	for _, name := range h.botUserAgents {
+	for _, name := range performanceToolUserAgents {
 		if strings.Contains(ua, name) {
 			return true
 		}
@@ -157,73 +674,511 @@ func (h *handler) isBot(ua string) bool {
 	return false
 }
 
-func (h *handler) containsIgnoredExtension(path string) bool {
-	path = strings.ToLower(path)
-	for _, name := range h.ignoredExtension {
-		if strings.Contains(path, name) {
+func (h *Prerenderer) isEligibleMethod(method string) bool {
+	for _, m := range h.methods {
+		if strings.EqualFold(m, method) {
 			return true
 		}
 	}
 	return false
 }
 
-func (h *handler) getPrerenderedPage(rw http.ResponseWriter, req1 *http.Request) {
-	h.logf("prerender: %q", req1.URL)
+// matchBot reports whether ua is a bot, with the matching bot pattern when
+// it is known.
+func (h *Prerenderer) matchBot(ua string) (string, bool) {
+	m := h.botMatcher
+	if h.botSource != nil {
+		if remote := h.botSource.BotMatcher(); remote != nil {
+			m = remote
+		}
+	}
+	if m != nil {
+		if name, ok := matchBot(m, ua); ok {
+			return name, true
+		}
+	}
+	if h.searchBots {
+		return matchBot(searchBotMatcher, ua)
+	}
+	return "", false
+}
+
+// isStreaming reports whether req upgrades the connection (like a WebSocket
+// handshake) or asks for an event stream. These always go to the app.
+func isStreaming(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptsHTML reports whether an Accept header value allows an HTML
+// response. A missing header accepts anything.
+func acceptsHTML(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		var (
+			params    = strings.Split(part, ";")
+			mediaType = strings.ToLower(strings.TrimSpace(params[0]))
+		)
+
+		if isZeroQuality(params[1:]) {
+			continue
+		}
 
-	rawurl, err := h.buildApiUrl(req1)
+		switch mediaType {
+		case "text/html", "application/xhtml+xml", "text/*", "*/*", "*":
+			return true
+		}
+	}
+	return false
+}
+
+func isZeroQuality(params []string) bool {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(param[2:], 64)
+		return err == nil && q == 0
+	}
+	return false
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	return matching(res, s) != nil
+}
+
+// matching returns the first of res matching s.
+func matching(res []*regexp.Regexp, s string) *regexp.Regexp {
+	if s == "" {
+		return nil
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return re
+		}
+	}
+	return nil
+}
+
+// ignoredExtension returns the ignored extension of the path p, if any.
+func (h *Prerenderer) ignoredExtension(p string) string {
+	name := strings.ToLower(path.Base(p))
+	for i := strings.IndexByte(name, '.'); i >= 0; {
+		if _, found := h.ignoredExtensions[name[i:]]; found {
+			return name[i:]
+		}
+
+		j := strings.IndexByte(name[i+1:], '.')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return ""
+}
+
+func (h *Prerenderer) getPrerenderedPage(rw http.ResponseWriter, req1 *http.Request, reason Reason) {
+	h.logReqf(req1, "prerender: %q", req1.URL)
+
+	start := time.Now()
+
+	u, err := h.requestURL(req1)
 	if err != nil {
-		h.logf("prerender error: %s", err)
+		h.logReqf(req1, "prerender error: %s", err)
 		http.Error(rw, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	req2, err := http.NewRequest("GET", rawurl, nil)
-	if err != nil {
-		h.logf("prerender error: %s", err)
-		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+	var (
+		key     = h.cacheKey(req1, u)
+		credits = 0
+		w       = &responseWriter{ResponseWriter: rw}
+	)
+
+	verdict, policy := h.classify(req1)
+	if policy == PolicyRender {
+		key = ""
+	}
+
+	var snap *Snapshot
+	if !bypassesCache(req1) {
+		snap = h.cached(req1.Context(), key)
+	}
+
+	if snap == nil && h.async != nil && h.cache != nil && key != "" {
+		h.renderAsync(req1, u, key, reason, verdict.Class)
+		h.sub.ServeHTTP(rw, req1)
 		return
 	}
 
-	req2.Header.Set("User-Agent", req1.UserAgent())
+	if snap != nil {
+		h.writeSnapshot(w.wrap(), req1, snap, credits)
+	} else {
+		var err error
+		if h.renderBudget > 0 {
+			err = h.budgetRender(w.wrap(), req1, u, key)
+		} else {
+			// The rate limit token is taken once the render can start,
+			// so renders waiting for a slot or overflowing don't use
+			// the quota.
+			if h.saturated(rw, req1, key) {
+				return
+			}
+			if h.throttled(rw, req1, key) {
+				h.releaseSlot()
+				return
+			}
+			err = h.proxyRender(w.wrap(), req1, u, key)
+			h.releaseSlot()
+		}
+		if err != nil {
+			return
+		}
+		credits = 1
+	}
 
-	if h.prerenderToken != "" {
-		req2.Header.Set(x_PRERENDER_TOKEN, h.prerenderToken)
+	h.emitRender(RenderEvent{
+		URL:         u,
+		UserAgent:   req1.UserAgent(),
+		CacheHit:    credits == 0,
+		Credits:     credits,
+		Bytes:       w.written,
+		Duration:    time.Since(start),
+		Status:      w.status,
+		Reason:      reason,
+		Class:       verdict.Class,
+		Correlation: h.correlation(req1),
+	})
+}
+
+// snapshotResponse returns the response serving snap to req.
+func (h *Prerenderer) snapshotResponse(req *http.Request, snap *Snapshot, credits int) (int, http.Header, []byte) {
+	body, coding := h.encode(req, snap)
+
+	header := make(http.Header, len(snap.Header)+4)
+	copyHeader(header, snap.Header)
+
+	if snap.Gzip != nil || h.compress || len(snap.Encoded) > 0 {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if len(h.locales) > 0 {
+		header.Add("Vary", "Accept-Language")
+	}
+	if h.mobileVariants {
+		header.Add("Vary", "User-Agent")
 	}
+	if coding != "" {
+		header.Set("Content-Encoding", coding)
+	}
+
+	if h.costHeader {
+		header.Set(x_PRERENDER_COST, costHeader(credits, int64(len(body))))
+	}
+
+	if snap.Status == http.StatusOK {
+		if !h.disableETags && snap.ETag != "" {
+			header.Set("ETag", encodedETag(snap.ETag, coding))
+		}
+		if h.cache != nil && !snap.RenderedAt.IsZero() {
+			header.Set("Last-Modified", snap.RenderedAt.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(req, header) {
+			header.Del("Content-Length")
+			return http.StatusNotModified, header, nil
+		}
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return snap.Status, header, body
+}
 
-	if h.prerenderUsername != "" || h.prerenderPassword != "" {
-		req2.SetBasicAuth(h.prerenderUsername, h.prerenderPassword)
+func (h *Prerenderer) writeSnapshot(rw http.ResponseWriter, req *http.Request, snap *Snapshot, credits int) {
+	status, header, body := h.snapshotResponse(req, snap, credits)
+
+	copyHeader(rw.Header(), header)
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+func (h *Prerenderer) render(req1 *http.Request, u *url.URL) (snap *Snapshot, err error) {
+	start := h.load.begin()
+	defer func() { h.load.end(start, err != nil) }()
+
+	req2, err := h.serviceRequest(req1, u)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req2)
+	resp, err := h.client.Do(req2)
 	if err != nil {
-		h.logf("prerender error: %s", err)
-		http.Error(rw, "Internal server error", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if resp, err = h.followRedirects(req1, u, resp); err != nil {
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	io.Copy(rw, resp.Body)
+	return h.newSnapshot(u, resp)
+}
+
+// serviceRequest returns the request to the prerender service which
+// renders the page at u for the crawler request req1.
+func (h *Prerenderer) serviceRequest(req1 *http.Request, u *url.URL) (*http.Request, error) {
+	ctx := httptrace.WithClientTrace(req1.Context(), h.load.trace())
+
+	req2, err := h.newServiceRequest(ctx, req1, u)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range append(h.forwardHeaders[:len(h.forwardHeaders):len(h.forwardHeaders)], h.correlationHeaders...) {
+		if values := req1.Header.Values(name); len(values) > 0 {
+			req2.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	if c := h.controls(u); c != nil {
+		for name, values := range c.Header {
+			req2.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	req2.Header.Set("User-Agent", req1.UserAgent())
+	if h.rendererMarker != "" {
+		req2.Header.Set(x_PRERENDER_RENDERER, h.rendererMarker)
+	}
+	// Asking for gzip explicitly disables the transparent decompression of
+	// the transport, so the compressed page can be passed on as is.
+	req2.Header.Set("Accept-Encoding", "gzip")
+
+	if locale := h.locale(req1); locale != "" {
+		req2.Header.Set("Accept-Language", locale)
+	}
+
+	if h.tokenProvider != nil {
+		token, err := h.tokenProvider.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("service token: %w", err)
+		}
+		if token != "" {
+			req2.Header.Set(x_PRERENDER_TOKEN, token)
+		}
+	}
+
+	if h.usernameProvider != nil || h.passwordProvider != nil {
+		var username, password string
+		if h.usernameProvider != nil {
+			if username, err = h.usernameProvider.Token(ctx); err != nil {
+				return nil, fmt.Errorf("service username: %w", err)
+			}
+		}
+		if h.passwordProvider != nil {
+			if password, err = h.passwordProvider.Token(ctx); err != nil {
+				return nil, fmt.Errorf("service password: %w", err)
+			}
+		}
+		req2.SetBasicAuth(username, password)
+	}
+
+	return req2, nil
 }
 
-func (h *handler) buildApiUrl(req *http.Request) (string, error) {
+// newSnapshot reads the page at u from the prerender service response.
+func (h *Prerenderer) newSnapshot(u *url.URL, resp *http.Response) (*Snapshot, error) {
+	var (
+		r   io.Reader = resp.Body
+		raw *bytes.Buffer
+	)
+	gzipped := strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip")
+	if gzipped {
+		// MaxResponseBytes applies to the decoded page; the encoded bytes
+		// are kept for pass-through.
+		raw = new(bytes.Buffer)
+		zr, err := gzip.NewReader(io.TeeReader(resp.Body, raw))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	body, truncated, err := h.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		URL:        u.String(),
+		Status:     resp.StatusCode,
+		Header:     make(http.Header),
+		Body:       body,
+		RenderedAt: time.Now(),
+	}
+	copyHeader(snap.Header, resp.Header)
+	snap.Header.Del("Date")
+
+	if gzipped {
+		// The encoded bytes of a truncated page are those of the full page.
+		if !truncated {
+			snap.Gzip = raw.Bytes()
+		}
+		snap.Header.Del("Content-Encoding")
+		snap.Header.Del("Content-Length")
+	}
+	h.filterHeaders(snap.Header)
+	h.limitHeaders(snap.Header)
+	applyMetaTags(snap)
+	if err := h.transform(u, snap); err != nil {
+		return nil, err
+	}
+
+	snap.ETag = computeETag(snap.Body)
+	h.encodeSnapshot(snap)
+
+	return snap, nil
+}
+
+// readBody reads the body of a prerender service response, enforcing
+// MaxResponseBytes, and reports whether it was truncated.
+func (h *Prerenderer) readBody(r io.Reader) ([]byte, bool, error) {
+	if h.maxResponseBytes <= 0 {
+		body, err := io.ReadAll(r)
+		return body, false, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, h.maxResponseBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) <= h.maxResponseBytes {
+		return body, false, nil
+	}
+
+	if !h.truncateOversized {
+		return nil, false, fmt.Errorf("response exceeds %d bytes", h.maxResponseBytes)
+	}
+
+	h.logf("prerender warning: truncated response to %d bytes", h.maxResponseBytes)
+	return body[:h.maxResponseBytes], true, nil
+}
+
+// sharedTransport is shared by all handlers so connections (and TLS
+// sessions) to the prerender service are reused across requests. Like
+// http.DefaultTransport, it uses the proxy of the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables.
+var sharedTransport = newTransport()
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	return t
+}
+
+// checkRedirect stops the client at redirects. Following them would fetch
+// the target from the origin instead of rendering it, and send the service
+// credentials along; followRedirects renders them instead.
+func (h *Prerenderer) checkRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// followRedirects replaces resp, the response of the prerender service for
+// the page at u, by the render of its redirect target on the same host, up
+// to FollowRedirects times.
+func (h *Prerenderer) followRedirects(req1 *http.Request, u *url.URL, resp *http.Response) (*http.Response, error) {
+	for i := 0; i < h.maxRedirects && isRedirect(resp.StatusCode); i++ {
+		target, err := u.Parse(resp.Header.Get("Location"))
+		if err != nil || !strings.EqualFold(target.Host, u.Host) || target.Scheme != "http" && target.Scheme != "https" {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+
+		req2, err := h.serviceRequest(req1, target)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = h.client.Do(req2); err != nil {
+			return nil, err
+		}
+		u = target
+	}
+	return resp, nil
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// hopHeaders are the hop-by-hop headers which must not be copied from the
+// prerender service response.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func isHopHeader(name string) bool {
+	for _, hop := range hopHeaders {
+		if hop == name {
+			return true
+		}
+	}
+	return false
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+	for _, name := range hopHeaders {
+		dst.Del(name)
+	}
+}
+
+// originalURL reconstructs the URL requested by the client.
+func (h *Prerenderer) originalURL(req *http.Request) (*url.URL, error) {
 	const (
 		CF_VISITOR        = "Cf-Visitor"
 		CF_HTTPS          = `"scheme":"https"`
+		FORWARDED         = "Forwarded"
 		X_FORWARDED_PROTO = "X-Forwarded-Proto"
-		X_FORWARDED_HTTPS = "https,"
+		X_FORWARDED_HOST  = "X-Forwarded-Host"
 		HTTP_HOST         = "Host"
 	)
 
 	var (
-		rawurl string
-		u      *url.URL
-		err    error
+		u   *url.URL
+		err error
 	)
 
 	u, err = url.ParseRequestURI(req.RequestURI)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	u.Host = req.Header.Get(HTTP_HOST)
@@ -233,28 +1188,110 @@ func (h *handler) buildApiUrl(req *http.Request) (string, error) {
 	if u.Host == "" {
 		u.Host = req.Host
 	}
+
+	var (
+		trusted     = h.trustsProxy(req)
+		proto, host string
+	)
+
+	if trusted {
+		proto, host = parseForwarded(req.Header.Get(FORWARDED))
+		if host == "" {
+			xfh, _, _ := strings.Cut(req.Header.Get(X_FORWARDED_HOST), ",")
+			host = strings.TrimSpace(xfh)
+		}
+	}
+	if host != "" {
+		u.Host = host
+	}
+	if h.canonicalHost != "" {
+		u.Host = h.canonicalHost
+	}
+
 	if u.Host == "" {
-		return "", errors.New("undetectable host")
+		return nil, errors.New("undetectable host")
 	}
 
 	u.Scheme = "http"
-
-	if strings.Contains(req.Header.Get(CF_VISITOR), CF_HTTPS) {
-		u.Scheme = "https"
-	} else if strings.HasPrefix(req.Header.Get(X_FORWARDED_PROTO), X_FORWARDED_HTTPS) {
+	if req.TLS != nil {
 		u.Scheme = "https"
 	}
 
-	rawurl = h.prerenderServiceURL
+	if trusted {
+		xfp, _, _ := strings.Cut(req.Header.Get(X_FORWARDED_PROTO), ",")
+
+		switch {
+		case proto == "http" || proto == "https":
+			u.Scheme = proto
+		case strings.Contains(req.Header.Get(CF_VISITOR), CF_HTTPS):
+			u.Scheme = "https"
+		case strings.EqualFold(strings.TrimSpace(xfp), "https"):
+			u.Scheme = "https"
+		}
+	}
+
+	u.Scheme, u.Host = h.stripPort(u.Scheme, u.Host)
+	h.normalizeURL(u)
+	h.translateEscapedFragment(u)
+
+	return u, nil
+}
+
+// targetURL normalizes an absolute URL like originalURL does for requests.
+func (h *Prerenderer) targetURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("not an absolute URL: %q", rawurl)
+	}
+
+	u.Fragment = ""
+	if h.canonicalHost != "" {
+		u.Host = h.canonicalHost
+	}
+	u.Scheme, u.Host = h.stripPort(u.Scheme, u.Host)
+	h.normalizeURL(u)
+	h.translateEscapedFragment(u)
+
+	return u, nil
+}
+
+// buildApiUrl returns the prerender service URL which renders u.
+func (h *Prerenderer) buildApiUrl(u *url.URL) string {
+	rawurl := h.serviceURL()
 	if !strings.HasSuffix(rawurl, "/") {
 		rawurl += "/"
 	}
 	rawurl += url.QueryEscape(u.String())
 
-	return rawurl, nil
+	return rawurl
+}
+
+// stripPort removes the port from host unless it is a non-standard port
+// that must be preserved. Port 443 implies https.
+func (h *Prerenderer) stripPort(scheme, host string) (string, string) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return scheme, host
+	}
+
+	switch {
+	case port == "443":
+		scheme = "https"
+	case port == "80" && scheme == "http":
+	case h.preservePorts:
+		return scheme, host
+	}
+
+	if strings.Contains(name, ":") {
+		name = "[" + name + "]"
+	}
+	return scheme, name
 }
 
-func (h *handler) logf(format string, args ...interface{}) {
+func (h *Prerenderer) logf(format string, args ...interface{}) {
 	if h.log != nil {
 		h.log.Printf(format, args...)
 	}
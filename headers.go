@@ -0,0 +1,89 @@
+package prerender
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ProxyHeaders controls which headers of the prerender service response are
+// copied to the client. Headers are matched case-insensitively against glob
+// patterns (like "X-*"). When allow is empty all headers are allowed; deny
+// takes precedence over allow.
+//
+//	ProxyHeaders(nil, []string{"Set-Cookie", "Server", "X-*"})
+func ProxyHeaders(allow, deny []string) Option {
+	return func(h *Prerenderer) {
+		h.allowHeaders, h.denyHeaders = allow, deny
+	}
+}
+
+func (h *Prerenderer) filterHeaders(header http.Header) {
+	if len(h.allowHeaders) == 0 && len(h.denyHeaders) == 0 {
+		return
+	}
+
+	for name := range header {
+		allowed := len(h.allowHeaders) == 0 || matchHeader(h.allowHeaders, name)
+		if !allowed || matchHeader(h.denyHeaders, name) {
+			delete(header, name)
+		}
+	}
+}
+
+func matchHeader(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxHeaders limits the number of upstream header values and their total
+// size in bytes which are copied to the client. Headers beyond the limits
+// are dropped with a logged warning. Zero means no limit.
+func MaxHeaders(count, size int) Option {
+	return func(h *Prerenderer) {
+		h.maxHeaderCount, h.maxHeaderBytes = count, size
+	}
+}
+
+func (h *Prerenderer) limitHeaders(header http.Header) {
+	if h.maxHeaderCount <= 0 && h.maxHeaderBytes <= 0 {
+		return
+	}
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var count, size, dropped int
+	for _, name := range names {
+		var kept []string
+		for _, value := range header[name] {
+			count++
+			size += len(name) + len(value) + len(": \r\n")
+
+			if (h.maxHeaderCount > 0 && count > h.maxHeaderCount) || (h.maxHeaderBytes > 0 && size > h.maxHeaderBytes) {
+				dropped++
+				continue
+			}
+			kept = append(kept, value)
+		}
+
+		if len(kept) == 0 {
+			delete(header, name)
+		} else {
+			header[name] = kept
+		}
+	}
+
+	if dropped > 0 {
+		h.logf("prerender warning: dropped %d upstream header values exceeding the limits", dropped)
+	}
+}
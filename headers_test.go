@@ -0,0 +1,83 @@
+package prerender
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFilterHeaders(t *testing.T) {
+	header := http.Header{
+		"Content-Type":  {"text/html"},
+		"Set-Cookie":    {"a=b"},
+		"Server":        {"renderer"},
+		"X-Prerender":   {"1"},
+		"Cache-Control": {"max-age=60"},
+	}
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		want        []string
+	}{
+		{"no policy", nil, nil, []string{"Cache-Control", "Content-Type", "Server", "Set-Cookie", "X-Prerender"}},
+		{"deny", nil, []string{"set-cookie", "Server"}, []string{"Cache-Control", "Content-Type", "X-Prerender"}},
+		{"deny glob", nil, []string{"X-*"}, []string{"Cache-Control", "Content-Type", "Server", "Set-Cookie"}},
+		{"allow", []string{"Content-Type", "cache-control"}, nil, []string{"Cache-Control", "Content-Type"}},
+		{"allow glob", []string{"C*"}, nil, []string{"Cache-Control", "Content-Type"}},
+		{"deny takes precedence", []string{"*"}, []string{"Set-*"}, []string{"Cache-Control", "Content-Type", "Server", "X-Prerender"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Prerenderer{allowHeaders: tt.allow, denyHeaders: tt.deny}
+			got := header.Clone()
+			h.filterHeaders(got)
+			if names := headerNames(got); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("headers = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitHeaders(t *testing.T) {
+	header := http.Header{
+		"A": {"1", "2"},
+		"B": {"3"},
+		"C": {"4"},
+	}
+
+	tests := []struct {
+		name        string
+		count, size int
+		want        http.Header
+	}{
+		{"no limit", 0, 0, header},
+		{"count", 2, 0, http.Header{"A": {"1", "2"}}},
+		{"count within value list", 1, 0, http.Header{"A": {"1"}}},
+		// Every value takes the size of "A: 1\r\n".
+		{"size", 0, 18, http.Header{"A": {"1", "2"}, "B": {"3"}}},
+		{"both", 3, 12, http.Header{"A": {"1", "2"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Prerenderer{maxHeaderCount: tt.count, maxHeaderBytes: tt.size}
+			got := header.Clone()
+			h.limitHeaders(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func headerNames(header http.Header) []string {
+	var names []string
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
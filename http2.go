@@ -0,0 +1,79 @@
+package prerender
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// http2Config configures HTTP/2 to the prerender service.
+type http2Config struct {
+	cleartext  bool
+	maxStreams int
+}
+
+// HTTP2 multiplexes the renders on HTTP/2 connections to the prerender
+// service, negotiated with TLS. With maxStreams > 0 at most maxStreams
+// renders are sent at a time and further renders wait for a free stream, so
+// a single connection carries them when the service allows as many streams.
+func HTTP2(maxStreams int) Option {
+	return func(h *Prerenderer) {
+		h.http2 = &http2Config{maxStreams: maxStreams}
+	}
+}
+
+// H2C is like HTTP2 for http service URLs, using HTTP/2 without TLS (h2c with
+// prior knowledge), for render farms on internal networks.
+func H2C(maxStreams int) Option {
+	return func(h *Prerenderer) {
+		h.http2 = &http2Config{cleartext: true, maxStreams: maxStreams}
+	}
+}
+
+// configure enables HTTP/2 in t.
+func (c *http2Config) configure(t *http.Transport) {
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP2(true)
+	if c.cleartext {
+		t.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		t.Protocols.SetHTTP1(true)
+	}
+}
+
+// streamLimiter limits the concurrent requests of a transport. A request
+// holds its stream until its response body is closed.
+type streamLimiter struct {
+	next    http.RoundTripper
+	streams chan struct{}
+}
+
+func (l streamLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case l.streams <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		<-l.streams
+		return nil, err
+	}
+
+	var once sync.Once
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { once.Do(func() { <-l.streams }) }}
+	return resp, nil
+}
+
+// releaseBody calls release when it is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,141 @@
+// Package robots parses robots.txt files, following RFC 9309 and the
+// extensions of the major crawlers (* and $ in paths).
+package robots
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxBytes is the size of robots.txt files crawlers parse at least, per
+// RFC 9309.
+const maxBytes = 500 << 10
+
+// Robots are the rules of a robots.txt file.
+type Robots struct {
+	groups []*group
+}
+
+type group struct {
+	agents []string
+	rules  []rule
+}
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+// Parse parses the robots.txt file read from r. Invalid lines are ignored,
+// like crawlers do.
+func Parse(r io.Reader) *Robots {
+	var (
+		robots  = new(Robots)
+		current *group
+		inRules bool
+	)
+
+	s := bufio.NewScanner(io.LimitReader(r, maxBytes))
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || inRules {
+				current = new(group)
+				robots.groups = append(robots.groups, current)
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if value != "" {
+				current.rules = append(current.rules, rule{allow: key == "allow", pattern: value})
+			}
+		}
+	}
+	return robots
+}
+
+// Allowed reports whether the crawler with userAgent may request path,
+// which includes the query. The rules of the groups with the longest
+// user-agent contained in userAgent apply, or else those of *; among them
+// the longest matching pattern decides, and Allow wins ties. It also
+// returns the deciding pattern, empty when no rule matches.
+func (r *Robots) Allowed(userAgent, path string) (bool, string) {
+	if path == "/robots.txt" {
+		return true, ""
+	}
+
+	var (
+		rules []rule
+		best  = -1
+		ua    = strings.ToLower(userAgent)
+	)
+	for _, g := range r.groups {
+		n := -1
+		for _, agent := range g.agents {
+			switch {
+			case agent == "*":
+				n = max(n, 0)
+			case agent != "" && strings.Contains(ua, agent):
+				n = max(n, len(agent))
+			}
+		}
+		if n < 0 || n < best {
+			continue
+		}
+		if n > best {
+			best, rules = n, nil
+		}
+		rules = append(rules, g.rules...)
+	}
+
+	var decisive *rule
+	for i := range rules {
+		rule := &rules[i]
+		if !match(rule.pattern, path) {
+			continue
+		}
+		if decisive == nil || len(rule.pattern) > len(decisive.pattern) ||
+			len(rule.pattern) == len(decisive.pattern) && rule.allow {
+			decisive = rule
+		}
+	}
+	if decisive == nil {
+		return true, ""
+	}
+	return decisive.allow, decisive.pattern
+}
+
+// match reports whether path matches pattern, where * matches any sequence
+// of characters and a final $ the end of the path.
+func match(pattern, path string) bool {
+	pattern, anchored := strings.CutSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return len(path)-pos >= len(part) && strings.HasSuffix(path, part)
+		}
+		j := strings.Index(path[pos:], part)
+		if j < 0 {
+			return false
+		}
+		pos += j + len(part)
+	}
+	return !anchored || pos == len(path)
+}
@@ -0,0 +1,114 @@
+package robots
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/admin", "/admin/users", true},
+		{"/admin", "/Admin", false},
+		{"/admin", "/", false},
+		{"/*.pdf", "/docs/a.pdf", true},
+		{"/*.pdf", "/docs/a.pdf?x=1", true},
+		{"/*.pdf$", "/docs/a.pdf", true},
+		{"/*.pdf$", "/docs/a.pdf?x=1", false},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/axxcyyb", false},
+		{"/page$", "/page", true},
+		{"/page$", "/pages", false},
+		{"/*$", "/anything", true},
+		{"/a*a$", "/a", false},
+		{"/a*a$", "/aa", true},
+		{"*", "/x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := match(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+const robotsTxt = `
+# Comments are ignored.
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.json$
+
+User-agent: Googlebot
+User-agent: Bingbot
+Disallow: /no-google # trailing comment
+Allow: /
+
+User-agent: Googlebot-Image
+Disallow: /
+
+Sitemap: https://example.com/sitemap.xml
+invalid line
+`
+
+func TestAllowed(t *testing.T) {
+	r := Parse(strings.NewReader(robotsTxt))
+
+	tests := []struct {
+		userAgent   string
+		path        string
+		want        bool
+		wantPattern string
+	}{
+		{"Mozilla/5.0", "/", true, ""},
+		{"Mozilla/5.0", "/private/x", false, "/private"},
+		{"Mozilla/5.0", "/private/public/x", true, "/private/public"},
+		{"Mozilla/5.0", "/data.json", false, "/*.json$"},
+		{"Mozilla/5.0", "/data.json?v=1", true, ""},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "/private/x", true, "/"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "/no-google", false, "/no-google"},
+		{"Mozilla/5.0 (compatible; bingbot/2.0)", "/no-google/x", false, "/no-google"},
+		{"Googlebot-Image/1.0", "/img.png", false, "/"},
+		{"Googlebot-Image/1.0", "/robots.txt", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent+" "+tt.path, func(t *testing.T) {
+			got, pattern := r.Allowed(tt.userAgent, tt.path)
+			if got != tt.want || pattern != tt.wantPattern {
+				t.Errorf("Allowed(%q, %q) = %v, %q, want %v, %q", tt.userAgent, tt.path, got, pattern, tt.want, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func TestAllowedTies(t *testing.T) {
+	tests := []struct {
+		name  string
+		robot string
+		path  string
+		want  bool
+	}{
+		{"allow wins ties", "User-agent: *\nDisallow: /page\nAllow: /page\n", "/page", true},
+		{"allow wins ties in any order", "User-agent: *\nAllow: /page\nDisallow: /page\n", "/page", true},
+		{"longest wins", "User-agent: *\nAllow: /p\nDisallow: /page\n", "/page", false},
+		{"empty disallow", "User-agent: *\nDisallow:\n", "/page", true},
+		{"rules without group", "Disallow: /\n", "/page", true},
+		{"groups of an agent merge", "User-agent: a\nDisallow: /x\n\nUser-agent: a\nDisallow: /y\n", "/y", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Parse(strings.NewReader(tt.robot))
+			if got, _ := r.Allowed("a", tt.path); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
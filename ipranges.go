@@ -0,0 +1,195 @@
+package prerender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Published IP ranges of crawlers, for IPRangePoller.
+const (
+	GooglebotIPRanges = "https://developers.google.com/static/search/apis/ipranges/googlebot.json"
+	BingbotIPRanges   = "https://www.bing.com/toolbox/bingbot.json"
+)
+
+// maxIPRangeBytes limits the size of an IP range document.
+const maxIPRangeBytes = 1 << 20
+
+// IPRanges are the IP address ranges of a crawler.
+type IPRanges interface {
+	Contains(ip net.IP) bool
+}
+
+// VerifyBotIPs only prerenders for requests with a User-Agent containing
+// userAgent (like "googlebot") when the client IP is in ranges. It is a
+// cheaper alternative to VerifyBots and takes precedence over it for the
+// crawler. Give it once per crawler.
+//
+// Use TrustedProxies behind a proxy; X-Forwarded-For is ignored otherwise.
+func VerifyBotIPs(userAgent string, ranges IPRanges) Option {
+	userAgent = strings.ToLower(userAgent)
+	return func(h *Prerenderer) {
+		h.botRanges = append(h.botRanges, botRanges{userAgent: userAgent, ranges: ranges})
+	}
+}
+
+type botRanges struct {
+	userAgent string
+	ranges    IPRanges
+}
+
+// rangesFor returns the IP ranges configured for the crawler ua claims to
+// be, or nil.
+func (h *Prerenderer) rangesFor(ua string) IPRanges {
+	ua = strings.ToLower(ua)
+	for _, r := range h.botRanges {
+		if strings.Contains(ua, r.userAgent) {
+			return r.ranges
+		}
+	}
+	return nil
+}
+
+// IPRangePoller is an IPRanges which periodically fetches published
+// crawler IP ranges. The document is either in the JSON format of Google
+// and Bing ({"prefixes": [{"ipv4Prefix": "66.249.64.0/27"}, ...]}) or a
+// plain list of CIDRs, one per line, like a list generated for Facebook's
+// AS32934. Until the first successful fetch it contains no addresses.
+type IPRangePoller struct {
+	URL string
+	// Interval is the time between fetches, by default a minute.
+	Interval time.Duration
+	Client   *http.Client
+
+	mtx  sync.RWMutex
+	nets []*net.IPNet
+}
+
+// NewIPRangePoller returns an IPRangePoller for url. Call Run to start
+// polling.
+func NewIPRangePoller(url string, interval time.Duration) *IPRangePoller {
+	return &IPRangePoller{URL: url, Interval: interval}
+}
+
+// Contains reports whether ip is in the most recently fetched ranges.
+func (p *IPRangePoller) Contains(ip net.IP) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls the IP ranges until ctx is done. Failed fetches keep the
+// previous ranges and are reported to logf when it is not nil.
+func (p *IPRangePoller) Run(ctx context.Context, logf func(format string, args ...interface{})) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Fetch(ctx); err != nil && logf != nil {
+			logf("prerender IP ranges error: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetch fetches the IP ranges once.
+func (p *IPRangePoller) Fetch(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIPRangeBytes))
+	if err != nil {
+		return err
+	}
+
+	nets, err := parseIPRanges(data)
+	if err != nil {
+		return err
+	}
+	if len(nets) == 0 {
+		return fmt.Errorf("no IP ranges")
+	}
+
+	p.mtx.Lock()
+	p.nets = nets
+	p.mtx.Unlock()
+	return nil
+}
+
+func parseIPRanges(data []byte) ([]*net.IPNet, error) {
+	var cidrs []string
+
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var doc struct {
+			Prefixes []struct {
+				IPv4Prefix string `json:"ipv4Prefix"`
+				IPv6Prefix string `json:"ipv6Prefix"`
+			} `json:"prefixes"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, prefix := range doc.Prefixes {
+			cidrs = append(cidrs, prefix.IPv4Prefix, prefix.IPv6Prefix)
+		}
+	} else {
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			line, _, _ := strings.Cut(s.Text(), "#")
+			cidrs = append(cidrs, strings.TrimSpace(line))
+		}
+	}
+
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,41 @@
+package prerender
+
+import (
+	"net/url"
+	"strings"
+)
+
+// An ObjectLayout maps cache keys to the object names of object storage
+// stores. The name of a key prefix must be a prefix of the names of all
+// keys starting with it, so stores can list keys by prefix.
+type ObjectLayout interface {
+	Name(key string) string
+	// Key returns the key of an object name, or false when the object
+	// isn't a snapshot.
+	Key(name string) (string, bool)
+}
+
+// PrefixLayout names objects prefix followed by the path escaped key,
+// keeping slashes. With the prefix "pages/" the key
+// "https://example.com/?q=1" is named "pages/https://example.com/%3Fq=1".
+func PrefixLayout(prefix string) ObjectLayout {
+	return prefixLayout(prefix)
+}
+
+type prefixLayout string
+
+func (l prefixLayout) Name(key string) string {
+	return string(l) + strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+}
+
+func (l prefixLayout) Key(name string) (string, bool) {
+	name, found := strings.CutPrefix(name, string(l))
+	if !found {
+		return "", false
+	}
+	key, err := url.PathUnescape(name)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
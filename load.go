@@ -0,0 +1,174 @@
+package prerender
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of a new sample in the latency moving average.
+const latencyWeight = 0.2
+
+// Load describes the current render demand of a Prerenderer. Autoscalers
+// of self-hosted render fleets can poll it (or push it to a metrics
+// gateway) to scale on actual prerender traffic.
+type Load struct {
+	// InFlight is the number of renders currently in progress.
+	InFlight int64
+	// Renders is the total number of completed renders.
+	Renders uint64
+	// Errors is the total number of failed renders.
+	Errors uint64
+	// Latency is a moving average of the render latency.
+	Latency time.Duration
+	// Throttled is the total number of renders refused by
+	// MaxRendersPerSecond.
+	Throttled uint64
+	// Queued is the number of renders waiting for a slot of
+	// MaxConcurrentRenders, and Saturated the total number of renders
+	// refused because none was available.
+	Queued    int
+	Saturated uint64
+
+	// NewConns and ReusedConns count the connections to the prerender
+	// service which were newly dialed or reused from the pool.
+	NewConns    uint64
+	ReusedConns uint64
+	// TLSHandshakes counts the TLS handshakes with the prerender service and
+	// TLSResumed the ones which resumed a previous session.
+	TLSHandshakes uint64
+	TLSResumed    uint64
+}
+
+type loadTracker struct {
+	mtx       sync.Mutex
+	inFlight  int64
+	renders   uint64
+	errors    uint64
+	latency   time.Duration
+	throttled uint64
+	saturated uint64
+
+	newConns      uint64
+	reusedConns   uint64
+	tlsHandshakes uint64
+	tlsResumed    uint64
+}
+
+func (t *loadTracker) begin() time.Time {
+	t.mtx.Lock()
+	t.inFlight++
+	t.mtx.Unlock()
+	return time.Now()
+}
+
+func (t *loadTracker) end(start time.Time, failed bool) {
+	d := time.Since(start)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.inFlight--
+	t.renders++
+	if failed {
+		t.errors++
+	}
+	if t.renders == 1 {
+		t.latency = d
+	} else {
+		t.latency += time.Duration(latencyWeight * float64(d-t.latency))
+	}
+}
+
+func (t *loadTracker) throttle() {
+	t.mtx.Lock()
+	t.throttled++
+	t.mtx.Unlock()
+}
+
+func (t *loadTracker) saturate() {
+	t.mtx.Lock()
+	t.saturated++
+	t.mtx.Unlock()
+}
+
+func (t *loadTracker) snapshot() Load {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return Load{
+		InFlight:  t.inFlight,
+		Renders:   t.renders,
+		Errors:    t.errors,
+		Latency:   t.latency,
+		Throttled: t.throttled,
+		Saturated: t.saturated,
+
+		NewConns:      t.newConns,
+		ReusedConns:   t.reusedConns,
+		TLSHandshakes: t.tlsHandshakes,
+		TLSResumed:    t.tlsResumed,
+	}
+}
+
+// trace returns a ClientTrace which records connection reuse and TLS
+// session resumption.
+func (t *loadTracker) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mtx.Lock()
+			if info.Reused {
+				t.reusedConns++
+			} else {
+				t.newConns++
+			}
+			t.mtx.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			t.mtx.Lock()
+			t.tlsHandshakes++
+			if state.DidResume {
+				t.tlsResumed++
+			}
+			t.mtx.Unlock()
+		},
+	}
+}
+
+// Load returns the current render load.
+func (h *Prerenderer) Load() Load {
+	load := h.load.snapshot()
+	if h.renderSlots != nil {
+		load.Queued = h.renderSlots.queueLen()
+	}
+	return load
+}
+
+// LoadHandler returns an http.Handler which reports the current render load
+// as JSON, suitable for polling by autoscalers.
+func (h *Prerenderer) LoadHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		load := h.Load()
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"in_flight":       load.InFlight,
+			"renders":         load.Renders,
+			"errors":          load.Errors,
+			"latency_seconds": load.Latency.Seconds(),
+			"queued":          load.Queued,
+			"saturated":       load.Saturated,
+			"throttled":       load.Throttled,
+			"new_conns":       load.NewConns,
+			"reused_conns":    load.ReusedConns,
+			"tls_handshakes":  load.TLSHandshakes,
+			"tls_resumed":     load.TLSResumed,
+		})
+	})
+}
@@ -0,0 +1,82 @@
+package prerender
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VaryByLocale renders and caches a variant of each page per locale. The
+// Accept-Language header of each request is mapped to the best matching
+// supported locale (the first one being the default), which is sent to the
+// renderer, so the cache holds at most one variant per supported locale.
+func VaryByLocale(supported ...string) Option {
+	return func(h *Prerenderer) {
+		h.locales = supported
+	}
+}
+
+// locale returns the supported locale best matching the Accept-Language
+// header of req, or "" when pages don't vary by locale.
+func (h *Prerenderer) locale(req *http.Request) string {
+	if len(h.locales) == 0 {
+		return ""
+	}
+	if req == nil {
+		return h.locales[0]
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		params := strings.Split(part, ";")
+		tag := strings.TrimSpace(params[0])
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if locale := matchLocale(t.tag, h.locales); locale != "" {
+			return locale
+		}
+	}
+	return h.locales[0]
+}
+
+// matchLocale matches tag exactly or by its primary language subtag.
+func matchLocale(tag string, supported []string) string {
+	for _, locale := range supported {
+		if strings.EqualFold(tag, locale) {
+			return locale
+		}
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	for _, locale := range supported {
+		p, _, _ := strings.Cut(locale, "-")
+		if strings.EqualFold(primary, p) {
+			return locale
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package prerender
+
+import (
+	"context"
+	"net/http"
+)
+
+const x_PRERENDER_RENDERER = "X-Prerender-Renderer"
+
+type rendererKey struct{}
+
+// RendererMarker identifies requests made by the renderer itself. value is
+// sent to the render service in the X-Prerender-Renderer header, and the
+// renderer should send it back in that header on the page request and all
+// its sub-requests (XHR, scripts and other assets), like with Puppeteer's
+// page.setExtraHTTPHeaders. The URL of the rendered page isn't changed.
+//
+// Marked requests are never prerendered and are passed to the app, which
+// can use IsRendererRequest to exclude them from analytics and rate limits.
+func RendererMarker(value string) Option {
+	return func(h *Prerenderer) {
+		h.rendererMarker = value
+	}
+}
+
+// IsRendererRequest reports whether req was made by the renderer, as
+// identified by the RendererMarker.
+func IsRendererRequest(req *http.Request) bool {
+	marked, _ := req.Context().Value(rendererKey{}).(bool)
+	return marked
+}
+
+// stripRendererMarker returns req, marked as made by the renderer, and true
+// if it carries the renderer marker.
+func (h *Prerenderer) stripRendererMarker(req *http.Request) (*http.Request, bool) {
+	if h.rendererMarker == "" || req.Header.Get(x_PRERENDER_RENDERER) != h.rendererMarker {
+		return req, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), rendererKey{}, true)), true
+}
@@ -0,0 +1,80 @@
+package prerender
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rendered pages can control the response sent to the crawler with meta
+// tags, like the prerender.io middlewares support:
+//
+//	<meta name="prerender-status-code" content="404">
+//	<meta name="prerender-status-code" content="301">
+//	<meta name="prerender-header" content="Location: https://example.com/">
+//	<meta name="prerender-header" content="Cache-Control: max-age=3600">
+const (
+	metaStatusCode = "prerender-status-code"
+	metaHeader     = "prerender-header"
+)
+
+var (
+	metaTagRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrRe = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// metaContents returns the content of all meta tags with the given name.
+func metaContents(body []byte, name string) []string {
+	var contents []string
+	for _, tag := range metaTagRe.FindAll(body, -1) {
+		var (
+			attrs   = metaAttrRe.FindAllSubmatch(tag, -1)
+			matched bool
+			content string
+		)
+		for _, attr := range attrs {
+			value := html.UnescapeString(string(attr[2]) + string(attr[3]) + string(attr[4]))
+			switch strings.ToLower(string(attr[1])) {
+			case "name":
+				matched = strings.EqualFold(value, name)
+			case "content":
+				content = value
+			}
+		}
+		if matched {
+			contents = append(contents, content)
+		}
+	}
+	return contents
+}
+
+// applyMetaTags relays the status code and the headers requested by the
+// rendered page.
+func applyMetaTags(snap *Snapshot) {
+	for _, content := range metaContents(snap.Body, metaStatusCode) {
+		if code, err := strconv.Atoi(strings.TrimSpace(content)); err == nil && code >= 100 && code <= 599 {
+			snap.Status = code
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, content := range metaContents(snap.Body, metaHeader) {
+		name, value, found := strings.Cut(content, ":")
+		if !found {
+			continue
+		}
+
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" || name == "Content-Length" || isHopHeader(name) {
+			continue
+		}
+
+		if !seen[name] {
+			snap.Header.Del(name)
+			seen[name] = true
+		}
+		snap.Header.Add(name, strings.TrimSpace(value))
+	}
+}
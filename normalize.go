@@ -0,0 +1,123 @@
+package prerender
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NormalizeURLs normalizes the URLs of pages before they are rendered and
+// cached, so the same page isn't rendered under many URL variants: the host
+// is lowercased, query parameters matching one of the glob patterns in
+// dropParams are removed (by default common tracking parameters like utm_*
+// and fbclid), the remaining parameters are sorted and duplicate slashes in
+// the path are collapsed. Default ports are always removed.
+func NormalizeURLs(dropParams ...string) Option {
+	if len(dropParams) == 0 {
+		dropParams = trackingParams
+	}
+	return func(h *Prerenderer) {
+		h.normalize = true
+		h.dropParams = dropParams
+	}
+}
+
+func (h *Prerenderer) normalizeURL(u *url.URL) {
+	if !h.normalize {
+		return
+	}
+
+	u.Host = strings.ToLower(u.Host)
+
+	u.Path = collapseSlashes(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = collapseSlashes(u.RawPath)
+	}
+
+	if u.RawQuery == "" {
+		return
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if !matchParam(h.dropParams, name) {
+			kept = append(kept, param)
+		}
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		a, _, _ := strings.Cut(kept[i], "=")
+		b, _, _ := strings.Cut(kept[j], "=")
+		return a < b
+	})
+	u.RawQuery = strings.Join(kept, "&")
+}
+
+func matchParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func collapseSlashes(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	return p
+}
+
+// TranslateEscapedFragment translates the _escaped_fragment_ parameter of
+// the AJAX crawling scheme back into the original URL sent to the renderer:
+// ?_escaped_fragment_=/foo/bar becomes #!/foo/bar. An empty parameter is
+// removed.
+func TranslateEscapedFragment() Option {
+	return func(h *Prerenderer) {
+		h.translateFragment = true
+	}
+}
+
+func (h *Prerenderer) translateEscapedFragment(u *url.URL) {
+	const ESCAPED_FRAGMENT = "_escaped_fragment_"
+
+	if !h.translateFragment || u.RawQuery == "" {
+		return
+	}
+
+	var (
+		params   = strings.Split(u.RawQuery, "&")
+		kept     = params[:0]
+		fragment string
+		found    bool
+	)
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if name != ESCAPED_FRAGMENT {
+			kept = append(kept, param)
+			continue
+		}
+		found = true
+		if v, err := url.QueryUnescape(value); err == nil {
+			fragment = v
+		}
+	}
+	if !found {
+		return
+	}
+
+	u.RawQuery = strings.Join(kept, "&")
+	if fragment != "" {
+		u.Fragment = "!" + fragment
+	}
+}
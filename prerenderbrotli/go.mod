@@ -0,0 +1,10 @@
+module github.com/fd/prerender/prerenderbrotli
+
+go 1.24
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/fd/prerender v0.0.0
+)
+
+replace github.com/fd/prerender => ../
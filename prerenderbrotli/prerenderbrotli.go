@@ -0,0 +1,30 @@
+// Package prerenderbrotli compresses prerendered pages with Brotli for the
+// clients which support it, in preference to gzip.
+//
+//	prerender.Handler(app, prerender.Compress(), prerenderbrotli.Compress(brotli.DefaultCompression))
+package prerenderbrotli
+
+import (
+	"bytes"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fd/prerender"
+)
+
+// Compress compresses prerendered pages with Brotli at level, from
+// brotli.BestSpeed to brotli.BestCompression. Pages are compressed once
+// when they are rendered, so high levels only slow down renders. It
+// requires prerender.Compress.
+func Compress(level int) prerender.Option {
+	return prerender.ContentEncoding("br", func(body []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		w := brotli.NewWriterLevel(&buf, level)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+}
@@ -0,0 +1,181 @@
+// Package prerendercaddy registers the http.handlers.prerender Caddy v2
+// module, which serves prerendered pages to bots. Build Caddy with it using
+// xcaddy:
+//
+//	xcaddy build --with github.com/fd/prerender/prerendercaddy
+//
+// Caddyfile:
+//
+//	route {
+//		prerender {
+//			token              {env.PRERENDER_TOKEN}
+//			service_url        http://localhost:3000/
+//			bots               twitterbot slackbot
+//			ignored_extensions .js .css .png
+//			cache              1000 1h
+//		}
+//		reverse_proxy localhost:8080
+//	}
+package prerendercaddy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+
+	"github.com/fd/prerender"
+)
+
+func init() {
+	caddy.RegisterModule(Prerender{})
+	httpcaddyfile.RegisterHandlerDirective("prerender", parseCaddyfile)
+}
+
+// Prerender is a Caddy HTTP handler which serves prerendered pages to bots
+// and passes all other requests on to the next handler.
+type Prerender struct {
+	// Token is the prerender.io token.
+	Token string `json:"token,omitempty"`
+	// ServiceURL is the URL of the prerender service.
+	ServiceURL string `json:"service_url,omitempty"`
+	// Bots replaces the default list of bot User-Agents.
+	Bots []string `json:"bots,omitempty"`
+	// IgnoredExtensions replaces the default list of ignored extensions.
+	IgnoredExtensions []string `json:"ignored_extensions,omitempty"`
+	// CacheMaxEntries enables an in-memory cache of that size.
+	CacheMaxEntries int `json:"cache_max_entries,omitempty"`
+	// CacheTTL is the time pages are served from the cache.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	h *prerender.Prerenderer
+}
+
+// CaddyModule returns the Caddy module information.
+func (Prerender) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.prerender",
+		New: func() caddy.Module { return new(Prerender) },
+	}
+}
+
+// Provision builds the prerender handler.
+func (p *Prerender) Provision(ctx caddy.Context) error {
+	options := []prerender.Option{
+		prerender.Logger(zap.NewStdLog(ctx.Logger())),
+	}
+	if p.Token != "" {
+		options = append(options, prerender.ServiceToken(p.Token))
+	}
+	if p.ServiceURL != "" {
+		options = append(options, prerender.ServiceURL(p.ServiceURL))
+	}
+	if p.Bots != nil {
+		options = append(options, prerender.Bots(p.Bots))
+	}
+	if p.IgnoredExtensions != nil {
+		options = append(options, prerender.IgnoredExtensions(p.IgnoredExtensions))
+	}
+	if p.CacheMaxEntries > 0 {
+		options = append(options, prerender.Cache(prerender.NewMemoryStore(p.CacheMaxEntries), time.Duration(p.CacheTTL)))
+	}
+
+	h, err := prerender.New(http.HandlerFunc(serveNext), options...)
+	if err != nil {
+		return err
+	}
+	p.h = h
+	return nil
+}
+
+type chainKey struct{}
+
+type chain struct {
+	next caddyhttp.Handler
+	err  error
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (p *Prerender) ServeHTTP(rw http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
+	ch := &chain{next: next}
+	p.h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), chainKey{}, ch)))
+	return ch.err
+}
+
+// serveNext continues the Caddy handler chain with the request as seen by
+// the prerender handler.
+func serveNext(rw http.ResponseWriter, req *http.Request) {
+	ch := req.Context().Value(chainKey{}).(*chain)
+	ch.err = ch.next.ServeHTTP(rw, req)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+func (p *Prerender) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "token":
+			if !d.AllArgs(&p.Token) {
+				return d.ArgErr()
+			}
+		case "service_url":
+			if !d.AllArgs(&p.ServiceURL) {
+				return d.ArgErr()
+			}
+		case "bots":
+			p.Bots = d.RemainingArgs()
+			if len(p.Bots) == 0 {
+				return d.ArgErr()
+			}
+		case "ignored_extensions":
+			p.IgnoredExtensions = d.RemainingArgs()
+			if len(p.IgnoredExtensions) == 0 {
+				return d.ArgErr()
+			}
+		case "cache":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return d.Errf("invalid cache size %q", args[0])
+			}
+			p.CacheMaxEntries = n
+			if len(args) == 2 {
+				ttl, err := caddy.ParseDuration(args[1])
+				if err != nil {
+					return d.Errf("invalid cache TTL %q: %v", args[1], err)
+				}
+				p.CacheTTL = caddy.Duration(ttl)
+			}
+		default:
+			return d.Errf("unrecognized subdirective %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := new(Prerender)
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Prerender)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Prerender)(nil)
+	_ caddyfile.Unmarshaler       = (*Prerender)(nil)
+)
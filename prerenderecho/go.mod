@@ -0,0 +1,22 @@
+module github.com/fd/prerender/prerenderecho
+
+go 1.25.0
+
+require (
+	github.com/fd/prerender v0.0.0
+	github.com/labstack/echo/v4 v4.9.1
+)
+
+require (
+	github.com/labstack/gommon v0.4.0 // indirect
+	github.com/mattn/go-colorable v0.1.11 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.1 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+)
+
+replace github.com/fd/prerender => ../
@@ -0,0 +1,60 @@
+// Package prerenderecho serves prerendered pages to bots from Echo.
+//
+//	e := echo.New()
+//	e.Use(prerenderecho.Middleware(prerender.ServiceToken(token)))
+package prerenderecho
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fd/prerender"
+	"github.com/labstack/echo/v4"
+)
+
+// A Policy reports whether a request may be prerendered, for example based
+// on the matched route in c.Path(). Requests it rejects are passed through.
+type Policy func(c echo.Context) bool
+
+type chainKey struct{}
+
+type chain struct {
+	c    echo.Context
+	next echo.HandlerFunc
+	err  error
+}
+
+// Middleware returns Echo middleware which serves prerendered pages to bots
+// and passes all other requests on to the next handler.
+func Middleware(options ...prerender.Option) echo.MiddlewareFunc {
+	return New(nil, options...)
+}
+
+// New returns Echo middleware like Middleware which only prerenders the
+// requests allowed by policy.
+func New(policy Policy, options ...prerender.Option) echo.MiddlewareFunc {
+	h := prerender.Handler(http.HandlerFunc(serveNext), options...)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if policy != nil && !policy(c) {
+				return next(c)
+			}
+
+			ch := &chain{c: c, next: next}
+			req := c.Request()
+			req = req.WithContext(context.WithValue(req.Context(), chainKey{}, ch))
+			h.ServeHTTP(c.Response(), req)
+
+			return ch.err
+		}
+	}
+}
+
+// serveNext continues the Echo handler chain with the request as seen by
+// the prerender handler.
+func serveNext(rw http.ResponseWriter, req *http.Request) {
+	ch := req.Context().Value(chainKey{}).(*chain)
+	ch.c.SetRequest(req)
+	ch.err = ch.next(ch.c)
+}
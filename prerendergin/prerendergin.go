@@ -0,0 +1,61 @@
+// Package prerendergin serves prerendered pages to bots from Gin.
+//
+//	r := gin.New()
+//	r.Use(prerendergin.Middleware(prerender.ServiceToken(token)))
+package prerendergin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fd/prerender"
+	"github.com/gin-gonic/gin"
+)
+
+// A Skipper reports whether the request must be passed through without
+// prerendering, for example based on c.FullPath().
+type Skipper func(c *gin.Context) bool
+
+type chainKey struct{}
+
+type chain struct {
+	c      *gin.Context
+	called bool
+}
+
+// Middleware returns Gin middleware which serves prerendered pages to bots
+// and passes all other requests on to the next handlers.
+func Middleware(options ...prerender.Option) gin.HandlerFunc {
+	return New(nil, options...)
+}
+
+// New returns Gin middleware like Middleware which passes the requests
+// selected by skip through without prerendering.
+func New(skip Skipper, options ...prerender.Option) gin.HandlerFunc {
+	h := prerender.Handler(http.HandlerFunc(next), options...)
+
+	return func(c *gin.Context) {
+		if skip != nil && skip(c) {
+			c.Next()
+			return
+		}
+
+		ch := &chain{c: c}
+		req := c.Request.WithContext(context.WithValue(c.Request.Context(), chainKey{}, ch))
+		h.ServeHTTP(c.Writer, req)
+
+		if !ch.called {
+			// The prerendered page was served.
+			c.Abort()
+		}
+	}
+}
+
+// next continues the Gin handler chain with the request as seen by the
+// prerender handler.
+func next(rw http.ResponseWriter, req *http.Request) {
+	ch := req.Context().Value(chainKey{}).(*chain)
+	ch.called = true
+	ch.c.Request = req
+	ch.c.Next()
+}
@@ -0,0 +1,10 @@
+module github.com/fd/prerender/prerenderlambda
+
+go 1.24
+
+require (
+	github.com/aws/aws-lambda-go v1.49.0
+	github.com/fd/prerender v0.0.0
+)
+
+replace github.com/fd/prerender => ../
@@ -0,0 +1,134 @@
+// Package prerenderlambda runs the prerender handler inside an AWS Lambda
+// function behind an API Gateway proxy integration, for serverless SPAs.
+//
+//	func main() {
+//		lambda.Start(prerenderlambda.Handler(app,
+//			prerender.ServiceToken(token),
+//			prerender.Cache(dynamostore.New(dynamodb.NewFromConfig(cfg), "prerender"), time.Hour),
+//		))
+//	}
+package prerenderlambda
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/fd/prerender"
+)
+
+// A Func handles API Gateway proxy requests.
+type Func func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Handler returns a Lambda handler which serves prerendered pages to bots
+// and passes all other requests to app.
+func Handler(app http.Handler, options ...prerender.Option) Func {
+	h := prerender.Handler(app, options...)
+
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		req, err := newRequest(ctx, event)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return newResponse(rec), nil
+	}
+}
+
+// newRequest converts an API Gateway proxy request to an http.Request.
+func newRequest(ctx context.Context, event events.APIGatewayProxyRequest) (*http.Request, error) {
+	header := make(http.Header)
+	for name, value := range event.Headers {
+		header.Set(name, value)
+	}
+	for name, values := range event.MultiValueHeaders {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	query := make(url.Values)
+	for name, value := range event.QueryStringParameters {
+		query.Set(name, value)
+	}
+	for name, values := range event.MultiValueQueryStringParameters {
+		query[name] = values
+	}
+
+	// API Gateway endpoints are only served over HTTPS.
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     header.Get("Host"),
+		Path:     event.Path,
+		RawQuery: query.Encode(),
+	}
+
+	body := event.Body
+	if event.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, event.HTTPMethod, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Host = u.Host
+	req.RemoteAddr = event.RequestContext.Identity.SourceIP
+	// The scheme of the rendered page follows the TLS state, unlike
+	// forwarded headers also with TrustedProxies.
+	req.TLS = &tls.ConnectionState{ServerName: u.Hostname()}
+	// Only the absolute form has the scheme; handlers expect the path.
+	req.RequestURI = u.RequestURI()
+
+	return req, nil
+}
+
+// newResponse converts a recorded response to an API Gateway proxy
+// response. Binary bodies are base64 encoded.
+func newResponse(rec *httptest.ResponseRecorder) events.APIGatewayProxyResponse {
+	resp := events.APIGatewayProxyResponse{
+		StatusCode:        rec.Code,
+		MultiValueHeaders: rec.Header(),
+	}
+
+	body := rec.Body.Bytes()
+	if isText(rec.Header()) {
+		resp.Body = string(body)
+	} else {
+		resp.Body = base64.StdEncoding.EncodeToString(body)
+		resp.IsBase64Encoded = true
+	}
+
+	return resp
+}
+
+// isText reports whether a response with header has an unencoded text
+// body.
+func isText(header http.Header) bool {
+	if ce := header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/javascript" ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/xml"
+}
@@ -0,0 +1,162 @@
+// Package prerendertest provides an in-process fake prerender service for
+// integration tests of apps using the prerender handler.
+//
+//	svc := prerendertest.NewService()
+//	defer svc.Close()
+//	svc.Respond("https://example.com/", prerendertest.Response{Body: "<h1>Hi</h1>"})
+//	h := prerender.Handler(app, prerender.ServiceURL(svc.URL))
+package prerendertest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Response is a scripted response of the fake service.
+type Response struct {
+	// Status defaults to 200.
+	Status int
+	Header http.Header
+	Body   string
+	// Latency delays the response, in addition to the latency of the
+	// service.
+	Latency time.Duration
+}
+
+// A Request is a request captured by the fake service.
+type Request struct {
+	// URL is the URL of the page to render.
+	URL    string
+	Method string
+	Header http.Header
+	Body   []byte
+}
+
+// A Service is a fake prerender service. It serves the default GET protocol
+// as well as POST requests with a JSON body containing the page URL, as sent
+// by prerender.JSONBackend.
+type Service struct {
+	*httptest.Server
+
+	mtx       sync.Mutex
+	responses map[string]Response
+	fallback  Response
+	latency   time.Duration
+	requests  []Request
+}
+
+// NewService starts a fake prerender service. Pages without a scripted
+// response render as an empty 200 page. The caller must Close the service.
+func NewService() *Service {
+	s := &Service{responses: make(map[string]Response)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Respond scripts the response for the page at rawurl. The renderer marker
+// and the query string are part of the URL.
+func (s *Service) Respond(rawurl string, resp Response) {
+	s.mtx.Lock()
+	s.responses[rawurl] = resp
+	s.mtx.Unlock()
+}
+
+// RespondDefault scripts the response for pages without a scripted
+// response.
+func (s *Service) RespondDefault(resp Response) {
+	s.mtx.Lock()
+	s.fallback = resp
+	s.mtx.Unlock()
+}
+
+// SetLatency delays all responses by d.
+func (s *Service) SetLatency(d time.Duration) {
+	s.mtx.Lock()
+	s.latency = d
+	s.mtx.Unlock()
+}
+
+// Requests returns the requests received so far.
+func (s *Service) Requests() []Request {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Reset removes the scripted responses, latency and captured requests.
+func (s *Service) Reset() {
+	s.mtx.Lock()
+	s.responses = make(map[string]Response)
+	s.fallback = Response{}
+	s.latency = 0
+	s.requests = nil
+	s.mtx.Unlock()
+}
+
+func (s *Service) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rawurl, err := pageURL(req, body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	s.requests = append(s.requests, Request{
+		URL:    rawurl,
+		Method: req.Method,
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	resp, found := s.responses[rawurl]
+	if !found {
+		resp = s.fallback
+	}
+	latency := s.latency + resp.Latency
+	s.mtx.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	for name, values := range resp.Header {
+		rw.Header()[name] = values
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	rw.WriteHeader(resp.Status)
+	io.WriteString(rw, resp.Body)
+}
+
+// pageURL returns the URL of the page requested by req.
+func pageURL(req *http.Request, body []byte) (string, error) {
+	if req.Method == "POST" {
+		var doc struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", err
+		}
+		return doc.URL, nil
+	}
+	return url.QueryUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/"))
+}
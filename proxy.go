@@ -0,0 +1,126 @@
+package prerender
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+type proxyKey struct{}
+
+// proxyRequest carries the state of a render through the reverse proxy.
+type proxyRequest struct {
+	req *http.Request
+	u   *url.URL
+	key string
+	err error
+}
+
+// newProxy returns the reverse proxy which renders pages. Requests to the
+// prerender service are built by serviceRequest and sent with the client of
+// the handler, so redirects, timeouts and injected faults apply.
+func (h *Prerenderer) newProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:       func(*http.Request) {},
+		Transport:      serviceTransport{h},
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.proxyError,
+		ErrorLog:       h.log,
+	}
+}
+
+// proxyRender renders the page at u for req1 through the reverse proxy,
+// caches it under key and serves it to rw.
+func (h *Prerenderer) proxyRender(rw http.ResponseWriter, req1 *http.Request, u *url.URL, key string) (err error) {
+	start := h.load.begin()
+	defer func() { h.load.end(start, err != nil) }()
+
+	pr := &proxyRequest{req: req1, u: u, key: key}
+
+	req2, err := h.serviceRequest(req1, u)
+	if err != nil {
+		// Like failed renders, the stale snapshot is served if any.
+		h.proxyError(rw, req1.WithContext(context.WithValue(req1.Context(), proxyKey{}, pr)), err)
+		return pr.err
+	}
+	req2 = req2.WithContext(context.WithValue(req2.Context(), proxyKey{}, pr))
+
+	h.proxy.ServeHTTP(rw, req2)
+	return pr.err
+}
+
+// modifyResponse turns the response of the prerender service into the
+// response serving the snapshot, and caches the snapshot. Server errors are
+// replaced by the stale snapshot with ServeStaleOnError.
+func (h *Prerenderer) modifyResponse(resp *http.Response) error {
+	pr := resp.Request.Context().Value(proxyKey{}).(*proxyRequest)
+
+	snap, err := h.newSnapshot(pr.u, resp)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var stale *Snapshot
+	if snap.Status >= 500 {
+		stale = h.staleSnapshot(pr.req.Context(), pr.key)
+	}
+
+	if stale != nil {
+		h.logReqf(pr.req, "prerender: render failed with status %d, serving stale %q", snap.Status, pr.req.URL)
+		snap = stale
+	} else {
+		h.store(pr.req.Context(), pr.key, snap)
+	}
+
+	status, header, body := h.snapshotResponse(pr.req, snap, 1)
+	if stale != nil {
+		header.Set(x_PRERENDER_STALE, "1")
+	}
+	resp.StatusCode = status
+	resp.Status = http.StatusText(status)
+	resp.Header = header
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Trailer = nil
+	return nil
+}
+
+// proxyError responds to failed renders, with the stale snapshot when
+// ServeStaleOnError is set.
+func (h *Prerenderer) proxyError(rw http.ResponseWriter, req *http.Request, err error) {
+	h.logReqf(req, "prerender error: %s", err)
+
+	if pr, ok := req.Context().Value(proxyKey{}).(*proxyRequest); ok {
+		if h.serveStale(rw, pr.req, pr.key) {
+			return
+		}
+		pr.err = err
+	}
+
+	http.Error(rw, "Internal server error", http.StatusInternalServerError)
+}
+
+// serviceTransport sends requests with the client of the handler, and
+// follows the redirects of renders.
+type serviceTransport struct {
+	h *Prerenderer
+}
+
+func (t serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if pr, ok := req.Context().Value(proxyKey{}).(*proxyRequest); ok {
+		if resp, err = t.h.followRedirects(pr.req, pr.u, resp); err != nil {
+			return nil, err
+		}
+		// modifyResponse finds the render in the request.
+		resp.Request = req
+	}
+	return resp, nil
+}
@@ -0,0 +1,79 @@
+package prerender
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// PurgeEndpoint returns an http.Handler which CI/CD pipelines can POST to
+// after a deploy to purge the cache. Requests must be authenticated with
+// secret, either as a bearer token or as an HMAC-SHA256 signature of the
+// body in the X-Prerender-Signature header. The body selects what to purge:
+//
+//	{"all": true}
+//	{"prefix": "https://example.com/blog/"}
+//	{"urls": ["https://example.com/", "https://example.com/about"]}
+func (h *Prerenderer) PurgeEndpoint(secret string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			rw.Header().Set("Allow", "POST")
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBytes))
+		if err != nil {
+			http.Error(rw, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		if !authorized(req, body, secret) {
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var purge struct {
+			All    bool     `json:"all"`
+			Prefix string   `json:"prefix"`
+			URLs   []string `json:"urls"`
+		}
+		if err := json.Unmarshal(body, &purge); err != nil {
+			http.Error(rw, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			ctx    = req.Context()
+			purged int
+		)
+
+		switch {
+		case purge.All:
+			purged, err = h.PurgeAll(ctx)
+		case purge.Prefix != "":
+			purged, err = h.PurgePrefix(ctx, purge.Prefix)
+		case len(purge.URLs) > 0:
+			for _, rawurl := range purge.URLs {
+				var n int
+				n, err = h.Purge(ctx, rawurl)
+				purged += n
+				if err != nil {
+					break
+				}
+			}
+		default:
+			http.Error(rw, "Nothing to purge", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			h.logf("prerender purge error: %s", err)
+			http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(map[string]interface{}{"purged": purged})
+	})
+}
@@ -0,0 +1,182 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errThrottled is returned for renders beyond MaxRendersPerSecond.
+var errThrottled = errors.New("prerender: render rate limit exceeded")
+
+// An Overflow is the handling of renders beyond MaxRendersPerSecond.
+type Overflow int
+
+const (
+	// OverflowApp serves the app.
+	OverflowApp Overflow = iota
+	// OverflowStale serves the cached page even when it expired, or the
+	// app when the page isn't cached.
+	OverflowStale
+	// OverflowTooManyRequests responds with 429 Too Many Requests.
+	OverflowTooManyRequests
+	// OverflowServiceUnavailable responds with 503 Service Unavailable.
+	OverflowServiceUnavailable
+)
+
+var overflowNames = []string{"app", "stale", "429", "503"}
+
+// String returns the name of o, like "stale".
+func (o Overflow) String() string {
+	if o < 0 || int(o) >= len(overflowNames) {
+		return fmt.Sprintf("Overflow(%d)", int(o))
+	}
+	return overflowNames[o]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o Overflow) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *Overflow) UnmarshalText(text []byte) error {
+	for i, name := range overflowNames {
+		if string(text) == name {
+			*o = Overflow(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("prerender: unknown overflow %q", text)
+}
+
+// MaxRendersPerSecond limits the requests to the prerender service made
+// for crawlers with a token bucket refilling at rate renders per second and
+// holding up to burst renders, so a scraping storm can't exhaust the render
+// quota. Cache hits are not limited. Renders beyond the limit are handled
+// according to RateLimitOverflow. The rate must be positive.
+func MaxRendersPerSecond(rate float64, burst int) Option {
+	return func(h *Prerenderer) {
+		h.renderRate, h.renderLimiter = rate, nil
+		if rate <= 0 {
+			h.optionError(fmt.Errorf("render rate %v must be positive", rate))
+			return
+		}
+		h.renderLimiter = newTokenBucket(rate, burst)
+	}
+}
+
+// RateLimitOverflow sets the handling of renders beyond
+// MaxRendersPerSecond. The default is OverflowApp.
+func RateLimitOverflow(o Overflow) Option {
+	return func(h *Prerenderer) {
+		h.overflow = o
+	}
+}
+
+// throttled reports whether the render limit is exceeded, in which case it
+// serves req according to the overflow handling.
+func (h *Prerenderer) throttled(rw http.ResponseWriter, req *http.Request, key string) bool {
+	if h.renderLimiter == nil || h.renderLimiter.allow() {
+		return false
+	}
+
+	h.load.throttle()
+	h.logReqf(req, "prerender: render rate limit exceeded: %q", req.URL)
+	h.serveOverflow(rw, req, key, h.overflow)
+	return true
+}
+
+// serveOverflow serves req, which can't be rendered now, according to o.
+func (h *Prerenderer) serveOverflow(rw http.ResponseWriter, req *http.Request, key string, o Overflow) {
+	switch o {
+	case OverflowStale:
+		if snap := h.lookup(req.Context(), key); snap != nil {
+			h.writeSnapshot(rw, req, snap, 0)
+			return
+		}
+	case OverflowTooManyRequests:
+		rw.Header().Set("Retry-After", "1")
+		http.Error(rw, "Too many requests", http.StatusTooManyRequests)
+		return
+	case OverflowServiceUnavailable:
+		rw.Header().Set("Retry-After", "1")
+		http.Error(rw, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	h.sub.ServeHTTP(rw, req)
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket which refills at rate tokens per
+// second and holds at most burst tokens.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait takes a token, waiting until one is available or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mtx.Lock()
+	b.refill(time.Now())
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mtx.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mtx.Lock()
+		b.tokens++
+		b.mtx.Unlock()
+		return ctx.Err()
+	}
+}
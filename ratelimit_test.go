@@ -0,0 +1,113 @@
+package prerender
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"empty", 2, 5, 0, 0, 0},
+		{"partial", 2, 5, 0, 500 * time.Millisecond, 1},
+		{"capped at burst", 2, 5, 4, 10 * time.Second, 5},
+		{"debt", 1, 1, -2, time.Second, -1},
+		{"burst at least one", 1, 0, 0, time.Hour, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate, tt.burst)
+			b.tokens = tt.tokens
+			b.refill(b.last.Add(tt.elapsed))
+			if b.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		burst int
+		want  int
+	}{
+		{"single", 1, 1},
+		{"burst", 3, 3},
+		{"zero burst", 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The rate is low enough that no token is added meanwhile.
+			b := newTokenBucket(0.001, tt.burst)
+			var n int
+			for i := 0; i < 10; i++ {
+				if b.allow() {
+					n++
+				}
+			}
+			if n != tt.want {
+				t.Errorf("allowed %d, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b := newTokenBucket(0.001, 1)
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatalf("wait with a token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("wait without a token = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The token of the canceled wait is given back.
+	if b.tokens > 0.1 || b.tokens < -0.1 {
+		t.Errorf("tokens = %v, want 0", b.tokens)
+	}
+}
+
+func TestOverflowText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Overflow
+		wantErr bool
+	}{
+		{"app", OverflowApp, false},
+		{"stale", OverflowStale, false},
+		{"429", OverflowTooManyRequests, false},
+		{"503", OverflowServiceUnavailable, false},
+		{"drop", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var o Overflow
+			err := o.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalText(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if o != tt.want {
+				t.Errorf("UnmarshalText(%q) = %v, want %v", tt.text, o, tt.want)
+			}
+			if text, _ := o.MarshalText(); string(text) != tt.text {
+				t.Errorf("MarshalText() = %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package prerender
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fd/prerender/internal/robots"
+)
+
+// RespectRobots passes crawler requests disallowed for the crawler by the
+// robots.txt of the app through to the app, so no renders are wasted on
+// pages well-behaved crawlers never request. robots.txt is requested from
+// the app for every host and kept for ttl; when it is missing or fails all
+// pages are allowed.
+func RespectRobots(ttl time.Duration) Option {
+	return func(h *Prerenderer) {
+		h.robots = &robotsCache{ttl: ttl}
+	}
+}
+
+// maxRobotsHosts limits the number of hosts of robotsCache.
+const maxRobotsHosts = 1000
+
+// robotsCache keeps the robots.txt of the app by host.
+type robotsCache struct {
+	ttl time.Duration
+
+	mtx   sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+// A robotsEntry is ready once the robots.txt of its host is fetched, so
+// concurrent requests for the host fetch it once.
+type robotsEntry struct {
+	ready   chan struct{}
+	robots  *robots.Robots
+	expires time.Time
+}
+
+// robotsAllowed reports whether the robots.txt of the app allows the
+// crawler of req to request it, and returns the deciding pattern.
+func (h *Prerenderer) robotsAllowed(req *http.Request) (bool, string) {
+	host := req.Host
+	if u, err := h.requestURL(req); err == nil {
+		host = u.Host
+	}
+
+	c := h.robots
+	c.mtx.Lock()
+	e, found := c.hosts[host]
+	if !found || e.isExpired() {
+		e = &robotsEntry{ready: make(chan struct{})}
+		if c.hosts == nil {
+			c.hosts = make(map[string]*robotsEntry)
+		}
+		if len(c.hosts) >= maxRobotsHosts {
+			for k := range c.hosts {
+				delete(c.hosts, k)
+				break
+			}
+		}
+		c.hosts[host] = e
+		c.mtx.Unlock()
+
+		e.robots = h.fetchRobots(req)
+		e.expires = time.Now().Add(c.ttl)
+		close(e.ready)
+	} else {
+		c.mtx.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+	case <-req.Context().Done():
+		return true, ""
+	}
+
+	if e.robots == nil {
+		return true, ""
+	}
+	return e.robots.Allowed(req.UserAgent(), req.URL.RequestURI())
+}
+
+// isExpired reports whether e is fetched and expired.
+func (e *robotsEntry) isExpired() bool {
+	select {
+	case <-e.ready:
+		return time.Now().After(e.expires)
+	default:
+		return false
+	}
+}
+
+// fetchRobots requests the robots.txt of the host of req from the app. It
+// returns nil, allowing all pages, when there is none.
+func (h *Prerenderer) fetchRobots(req *http.Request) *robots.Robots {
+	robotsReq, err := http.NewRequestWithContext(req.Context(), "GET", "/robots.txt", nil)
+	if err != nil {
+		return nil
+	}
+	robotsReq.Host = req.Host
+	robotsReq.RemoteAddr = req.RemoteAddr
+	robotsReq.TLS = req.TLS
+	for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded"} {
+		if v := req.Header.Values(name); len(v) > 0 {
+			robotsReq.Header[name] = v
+		}
+	}
+
+	buf := &bufferedResponse{header: make(http.Header)}
+	h.sub.ServeHTTP(buf, robotsReq)
+
+	switch {
+	case buf.status == 0 || buf.status >= 200 && buf.status < 300:
+		return robots.Parse(bytes.NewReader(buf.body.Bytes()))
+	case buf.status >= 400 && buf.status < 500:
+		return nil
+	default:
+		h.logReqf(req, "prerender: robots.txt of %s: unexpected status %d, allowing all pages", req.Host, buf.status)
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+// Package s3store stores prerendered pages in an Amazon S3 (or S3
+// compatible) bucket, shared by all instances of the app.
+//
+// Objects carry their expiry time in the "prerender-expires" metadata (Unix
+// seconds). S3 can't expire objects by metadata; use a lifecycle rule to
+// delete objects some days after their creation instead.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/fd/prerender"
+)
+
+// API is the part of the S3 client used by Store.
+type API interface {
+	GetObject(ctx context.Context, in *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// Store is a prerender.Store backed by an S3 bucket.
+type Store struct {
+	client API
+	bucket string
+
+	// Layout names the objects. It defaults to prerender.PrefixLayout("").
+	Layout prerender.ObjectLayout
+	// Retention is the time snapshots are kept after they expire, so they
+	// can still be served within a MinRenderInterval. It is added to the
+	// expiry time in the object metadata.
+	Retention time.Duration
+}
+
+// New returns a Store using the bucket.
+func New(client API, bucket string) *Store {
+	return &Store{client: client, bucket: bucket}
+}
+
+func (s *Store) layout() prerender.ObjectLayout {
+	if s.Layout == nil {
+		return prerender.PrefixLayout("")
+	}
+	return s.Layout
+}
+
+// Get implements prerender.Store.
+func (s *Store) Get(ctx context.Context, key string) (*prerender.Snapshot, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.layout().Name(key)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, prerender.ErrNotCached
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var snap prerender.Snapshot
+	if err := json.NewDecoder(out.Body).Decode(&snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Put implements prerender.Store.
+func (s *Store) Put(ctx context.Context, key string, snap *prerender.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.layout().Name(key)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if !snap.ExpiresAt.IsZero() {
+		expires := snap.ExpiresAt.Add(s.Retention).Unix()
+		in.Metadata = map[string]string{"prerender-expires": strconv.FormatInt(expires, 10)}
+	}
+
+	_, err = s.client.PutObject(ctx, in)
+	return err
+}
+
+// Delete implements prerender.Store.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.layout().Name(key)),
+	})
+	return err
+}
+
+// Keys implements prerender.Store.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.layout().Name(prefix)),
+	}
+
+	var keys []string
+	for p := s3.NewListObjectsV2Paginator(s.client, in); p.HasMorePages(); {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			if key, ok := s.layout().Key(aws.ToString(obj.Key)); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,90 @@
+package prerender
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxShadowRenders limits the concurrent renders of ShadowMode. Renders
+	// beyond the limit are dropped.
+	maxShadowRenders = 16
+	// shadowTimeout bounds a render of ShadowMode.
+	shadowTimeout = time.Minute
+)
+
+// ShadowMode serves all requests with the app, but renders the pages bots
+// would have been served in the background and reports the results
+// (rendered status and size, render latency and the response of the app)
+// to the log and OnRender. Shadow renders are not cached. It validates the
+// prerendered pages in production before they are served.
+func ShadowMode() Option {
+	return func(h *Prerenderer) {
+		h.shadow = make(chan struct{}, maxShadowRenders)
+	}
+}
+
+// shadowRender serves req with the app and renders the page in the
+// background.
+func (h *Prerenderer) shadowRender(rw http.ResponseWriter, req *http.Request, reason Reason) {
+	u, err := h.requestURL(req)
+	if err != nil {
+		h.sub.ServeHTTP(rw, req)
+		return
+	}
+
+	// The app may modify req, and its context ends with the response.
+	req2 := req.Clone(context.WithoutCancel(req.Context()))
+
+	w := &responseWriter{ResponseWriter: rw}
+	h.sub.ServeHTTP(w.wrap(), req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if h.renderLimiter != nil && !h.renderLimiter.allow() {
+		h.load.throttle()
+		h.logReqf(req, "prerender shadow: render rate limit exceeded: %s", u)
+		return
+	}
+
+	select {
+	case h.shadow <- struct{}{}:
+	default:
+		h.logReqf(req, "prerender shadow: dropped %s", u)
+		return
+	}
+
+	go func() {
+		defer func() { <-h.shadow }()
+
+		ctx, cancel := context.WithTimeout(req2.Context(), shadowTimeout)
+		defer cancel()
+
+		start := time.Now()
+		snap, err := h.render(req2.WithContext(ctx), u)
+		if err != nil {
+			h.logReqf(req2, "prerender shadow error: %s: %s", u, err)
+			return
+		}
+
+		e := RenderEvent{
+			URL:         u,
+			UserAgent:   req2.UserAgent(),
+			Credits:     1,
+			Bytes:       int64(len(snap.Body)),
+			Duration:    time.Since(start),
+			Shadow:      true,
+			Status:      snap.Status,
+			Reason:      reason,
+			AppStatus:   w.status,
+			AppBytes:    w.written,
+			Correlation: h.correlation(req2),
+		}
+
+		h.logReqf(req2, "prerender shadow: %s status=%d app_status=%d bytes=%d app_bytes=%d latency=%s",
+			u, e.Status, e.AppStatus, e.Bytes, e.AppBytes, e.Duration)
+		h.emitRender(e)
+	}()
+}
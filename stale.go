@@ -0,0 +1,41 @@
+package prerender
+
+import (
+	"context"
+	"net/http"
+)
+
+const x_PRERENDER_STALE = "X-Prerender-Stale"
+
+// ServeStaleOnError serves the cached snapshot of a page, even when it
+// expired, when rendering it fails (the prerender service is unreachable,
+// times out or responds with a server error). Stale responses carry an
+// X-Prerender-Stale: 1 header. It keeps pages indexable during incidents.
+func ServeStaleOnError() Option {
+	return func(h *Prerenderer) {
+		h.staleOnError = true
+	}
+}
+
+// staleSnapshot returns the snapshot cached under key to serve instead of a
+// failed render, or nil.
+func (h *Prerenderer) staleSnapshot(ctx context.Context, key string) *Snapshot {
+	if !h.staleOnError {
+		return nil
+	}
+	return h.lookup(ctx, key)
+}
+
+// serveStale serves the snapshot cached under key instead of a failed
+// render and reports whether there was one.
+func (h *Prerenderer) serveStale(rw http.ResponseWriter, req *http.Request, key string) bool {
+	snap := h.staleSnapshot(req.Context(), key)
+	if snap == nil {
+		return false
+	}
+
+	h.logReqf(req, "prerender: serving stale %q", req.URL)
+	rw.Header().Set(x_PRERENDER_STALE, "1")
+	h.writeSnapshot(rw, req, snap, 0)
+	return true
+}
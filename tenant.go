@@ -0,0 +1,85 @@
+package prerender
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ForHost applies options to the requests for host only, on top of the
+// other options, so one handler can serve many domains with their own
+// service URL, token, whitelist or cache namespace. ForHost can be given
+// several times for the same host; its options are applied in order. A
+// port in host is ignored, like when matching requests.
+//
+// Each host is served by its own Prerenderer, with its own load statistics.
+// It starts from the configured handler and shares what the other options
+// set up, like the cache and the limits of MaxRendersPerSecond and
+// MaxConcurrentRenders, unless options give the host its own.
+func ForHost(host string, options ...Option) Option {
+	host = strings.ToLower((&url.URL{Host: host}).Hostname())
+	return func(h *Prerenderer) {
+		if h.tenant {
+			return
+		}
+		if h.tenantOptions == nil {
+			h.tenantOptions = make(map[string][]Option)
+		}
+		h.tenantOptions[host] = append(h.tenantOptions[host], options...)
+	}
+}
+
+// newTenant returns the Prerenderer of a host: a copy of h, with the
+// environment and the options of h applied, and hostOptions applied on top.
+// The options of h don't run again, so the tenant shares their state.
+func (h *Prerenderer) newTenant(hostOptions []Option) *Prerenderer {
+	t := new(Prerenderer)
+	*t = *h
+	t.tenant, t.tenantOptions, t.tenants, t.errs = true, nil, nil, nil
+	t.load = new(loadTracker)
+	t.client = &http.Client{CheckRedirect: t.checkRedirect, Timeout: h.client.Timeout}
+
+	// The options of the host must not modify the configuration of h.
+	t.classPolicies = maps.Clone(h.classPolicies)
+	t.botRanges = h.botRanges[:len(h.botRanges):len(h.botRanges)]
+	t.pathTTLs = h.pathTTLs[:len(h.pathTTLs):len(h.pathTTLs)]
+	t.pathControls = h.pathControls[:len(h.pathControls):len(h.pathControls)]
+	t.transformers = h.transformers[:len(h.transformers):len(h.transformers)]
+	t.transportOptions = h.transportOptions[:len(h.transportOptions):len(h.transportOptions)]
+
+	for _, option := range hostOptions {
+		option(t)
+	}
+	t.configureClient()
+	return t
+}
+
+// tenantFor returns the Prerenderer configured for the host of req, or nil
+// when the host has no specific configuration.
+func (h *Prerenderer) tenantFor(req *http.Request) *Prerenderer {
+	if len(h.tenants) == 0 {
+		return nil
+	}
+
+	u, err := h.originalURL(req)
+	if err != nil {
+		return nil
+	}
+	return h.tenants[strings.ToLower(u.Hostname())]
+}
+
+// TokenForHost sets the prerender service token per host, so domains served
+// by the same process bill to different prerender.io accounts. Hosts
+// without a token use the default token.
+func TokenForHost(tokens map[string]string) Option {
+	options := make([]Option, 0, len(tokens))
+	for host, token := range tokens {
+		options = append(options, ForHost(host, ServiceToken(token)))
+	}
+	return func(h *Prerenderer) {
+		for _, option := range options {
+			option(h)
+		}
+	}
+}
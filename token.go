@@ -0,0 +1,101 @@
+package prerender
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TokenProvider returns the prerender service token. It is called for
+// every render, so tokens can be rotated without a restart.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenProvider returning a fixed token.
+type StaticToken string
+
+// Token implements TokenProvider.
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// EnvToken is a TokenProvider returning the value of an environment
+// variable.
+type EnvToken string
+
+// Token implements TokenProvider.
+func (name EnvToken) Token(ctx context.Context) (string, error) {
+	return os.Getenv(string(name)), nil
+}
+
+// FileToken returns a TokenProvider reading the token from the file at path,
+// without surrounding whitespace. The file is read again when it changes.
+func FileToken(path string) TokenProvider {
+	return &fileToken{path: path}
+}
+
+type fileToken struct {
+	path string
+
+	mtx     sync.Mutex
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+// Token implements TokenProvider.
+func (f *fileToken) Token(ctx context.Context) (string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.token = strings.TrimSpace(string(data))
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return f.token, nil
+}
+
+// ServiceTokenProvider sets the provider of the prerender service token.
+func ServiceTokenProvider(p TokenProvider) Option {
+	return func(h *Prerenderer) {
+		h.tokenProvider = p
+	}
+}
+
+// ServiceTokenFile reads the prerender service token from the file at path,
+// like a Docker or Kubernetes secret, and reads it again when it changes.
+// New reports a file which can't be read; renders fail while it can't.
+func ServiceTokenFile(path string) Option {
+	return func(h *Prerenderer) {
+		h.tokenProvider = FileToken(path)
+	}
+}
+
+// ServiceAuthFiles is like ServiceAuth with the username and password read
+// from files like ServiceTokenFile. An empty passwordFile sends an empty
+// password.
+func ServiceAuthFiles(usernameFile, passwordFile string) Option {
+	return func(h *Prerenderer) {
+		h.usernameProvider, h.passwordProvider = nil, nil
+		if usernameFile != "" {
+			h.usernameProvider = FileToken(usernameFile)
+		}
+		if passwordFile != "" {
+			h.passwordProvider = FileToken(passwordFile)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package prerender
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// A RenderResult is a rendered page before it is cached and served.
+type RenderResult struct {
+	// URL is the original URL of the page.
+	URL    *url.URL
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// A Transformer modifies a rendered page. An error fails the render.
+type Transformer func(r *RenderResult) error
+
+// Transformers adds stages which modify rendered pages, in order, like
+// minifying the HTML or rewriting links. Pages are transformed once, before
+// they are cached, including pages with error status codes. The meta tags
+// controlling the response are applied before.
+func Transformers(stages ...Transformer) Option {
+	return func(h *Prerenderer) {
+		h.transformers = append(h.transformers, stages...)
+	}
+}
+
+// transform runs the transformers on snap, the rendered page at u.
+func (h *Prerenderer) transform(u *url.URL, snap *Snapshot) error {
+	if len(h.transformers) == 0 {
+		return nil
+	}
+
+	r := &RenderResult{
+		URL:    u,
+		Status: snap.Status,
+		Header: snap.Header,
+		Body:   snap.Body,
+	}
+	for _, stage := range h.transformers {
+		if err := stage(r); err != nil {
+			return fmt.Errorf("transform %s: %w", u, err)
+		}
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+
+	// The body encoded by the prerender service is stale.
+	if snap.Gzip != nil && !bytes.Equal(r.Body, snap.Body) {
+		snap.Gzip = nil
+	}
+	snap.Status, snap.Header, snap.Body = r.Status, r.Header, r.Body
+	return nil
+}
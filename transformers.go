@@ -0,0 +1,366 @@
+package prerender
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Built-in transformers, see Transformers. They only modify HTML pages.
+
+var (
+	linkTagRe = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	baseTagRe = regexp.MustCompile(`(?is)<base\s[^>]*>`)
+	headTagRe = regexp.MustCompile(`(?is)<head(?:\s[^>]*)?>`)
+
+	// minifyRe matches the comments and the elements whose content
+	// MinifyHTML keeps.
+	minifyRe     = regexp.MustCompile(`(?is)<!--.*?-->|<pre\b.*?</pre\s*>|<textarea\b.*?</textarea\s*>|<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+	whitespaceRe = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+	scriptRe = regexp.MustCompile(`(?is)(<script\b[^>]*>).*?</script\s*>`)
+
+	// tagRe matches start tags, and script and style elements, whose
+	// content is skipped.
+	tagRe     = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>|<[a-z][^>]*>`)
+	bodyTagRe = regexp.MustCompile(`(?is)<body\b[^>]*>`)
+	styleRe   = regexp.MustCompile(`(?is)<style\b.*?</style\s*>`)
+	anyTagRe  = regexp.MustCompile(`(?s)<[^>]*>`)
+	titleRe   = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+	urlAttrRe = regexp.MustCompile(`(?is)(\s(?:href|src|action|poster|srcset)\s*=\s*)("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// defaultRendererHosts are the hosts CanonicalTags rewrites by default.
+var defaultRendererHosts = []string{"localhost", "127.0.0.1", "[::1]"}
+
+// CanonicalTags returns a Transformer which fixes the <link rel="canonical">
+// and <base href> tags of pages, as render farms often emit URLs pointing
+// at the renderer. URLs with one of rendererHosts (by default localhost
+// and the loopback addresses) are rewritten to the scheme and host of the
+// page URL, and relative URLs are made absolute. Missing tags are added
+// with the page URL.
+func CanonicalTags(rendererHosts ...string) Transformer {
+	if len(rendererHosts) == 0 {
+		rendererHosts = defaultRendererHosts
+	}
+
+	return func(r *RenderResult) error {
+		if !isHTMLResult(r) {
+			return nil
+		}
+
+		page := *r.URL
+		page.Fragment = ""
+
+		fix := func(href string) string {
+			u, err := page.Parse(href)
+			if err != nil {
+				return href
+			}
+			for _, host := range rendererHosts {
+				if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+					u.Scheme, u.Host = page.Scheme, page.Host
+					break
+				}
+			}
+			return u.String()
+		}
+
+		var canonical bool
+		r.Body = linkTagRe.ReplaceAllFunc(r.Body, func(tag []byte) []byte {
+			attrs := tagAttrs(tag)
+			if !hasToken(attrs["rel"], "canonical") {
+				return tag
+			}
+			canonical = true
+			return setAttr(tag, "href", fix(attrs["href"]))
+		})
+
+		var base bool
+		r.Body = baseTagRe.ReplaceAllFunc(r.Body, func(tag []byte) []byte {
+			attrs := tagAttrs(tag)
+			if _, found := attrs["href"]; !found {
+				return tag
+			}
+			base = true
+			return setAttr(tag, "href", fix(attrs["href"]))
+		})
+
+		var tags string
+		if !base {
+			tags += `<base href="` + html.EscapeString(page.String()) + `">`
+		}
+		if !canonical {
+			tags += `<link rel="canonical" href="` + html.EscapeString(page.String()) + `">`
+		}
+		r.Body = insertInHead(r.Body, tags)
+		return nil
+	}
+}
+
+// MinifyHTML returns a Transformer which shrinks pages by removing
+// comments (except conditional comments) and collapsing whitespace. The
+// content of <pre>, <textarea>, <script> and <style> elements is kept.
+func MinifyHTML() Transformer {
+	return func(r *RenderResult) error {
+		if !isHTMLResult(r) {
+			return nil
+		}
+
+		var (
+			out  = make([]byte, 0, len(r.Body))
+			text []byte
+			last = 0
+		)
+		for _, loc := range minifyRe.FindAllIndex(r.Body, -1) {
+			text = append(text, r.Body[last:loc[0]]...)
+			last = loc[1]
+
+			// Text around removed comments is collapsed as a whole.
+			m := r.Body[loc[0]:loc[1]]
+			if bytes.HasPrefix(m, []byte("<!--")) && !bytes.HasPrefix(m, []byte("<!--[if")) && !bytes.HasPrefix(m, []byte("<!--<![endif]")) {
+				continue
+			}
+
+			out = append(out, whitespaceRe.ReplaceAll(text, []byte(" "))...)
+			out = append(out, m...)
+			text = text[:0]
+		}
+		text = append(text, r.Body[last:]...)
+		out = append(out, whitespaceRe.ReplaceAll(text, []byte(" "))...)
+
+		r.Body = out
+		return nil
+	}
+}
+
+// StripScripts returns a Transformer which removes the <script> elements of
+// pages, as crawlers don't need the JS bundle, which often doubles the size
+// of the page. JSON-LD structured data is kept unless stripJSONLD is set,
+// and so are the scripts with a src containing one of keep, like
+// "maps.googleapis.com".
+func StripScripts(stripJSONLD bool, keep ...string) Transformer {
+	return func(r *RenderResult) error {
+		if !isHTMLResult(r) {
+			return nil
+		}
+
+		r.Body = scriptRe.ReplaceAllFunc(r.Body, func(script []byte) []byte {
+			attrs := tagAttrs(scriptRe.FindSubmatch(script)[1])
+
+			if !stripJSONLD && strings.EqualFold(strings.TrimSpace(attrs["type"]), "application/ld+json") {
+				return script
+			}
+			if src := attrs["src"]; src != "" {
+				for _, s := range keep {
+					if strings.Contains(src, s) {
+						return script
+					}
+				}
+			}
+			return nil
+		})
+		return nil
+	}
+}
+
+// AbsoluteLinks returns a Transformer which rewrites the relative URLs of
+// links and assets (the href, src, srcset, action and poster attributes)
+// to absolute URLs, resolved against the <base href> of the page or its URL
+// (which uses the CanonicalHost). Social bots otherwise resolve them
+// against the host of the prerender service. Fragments like "#top" are
+// kept.
+func AbsoluteLinks() Transformer {
+	return func(r *RenderResult) error {
+		if !isHTMLResult(r) {
+			return nil
+		}
+
+		base := r.URL
+		if tag := baseTagRe.Find(r.Body); tag != nil {
+			if href, found := tagAttrs(tag)["href"]; found {
+				if u, err := r.URL.Parse(href); err == nil {
+					base = u
+				}
+			}
+		}
+
+		resolve := func(ref string) string {
+			if ref == "" || strings.HasPrefix(ref, "#") {
+				return ref
+			}
+			u, err := url.Parse(ref)
+			if err != nil || u.IsAbs() {
+				return ref
+			}
+			return base.ResolveReference(u).String()
+		}
+
+		rewrite := func(tag []byte) []byte {
+			if baseTagRe.Match(tag) {
+				return tag
+			}
+			return urlAttrRe.ReplaceAllFunc(tag, func(attr []byte) []byte {
+				m := urlAttrRe.FindSubmatch(attr)
+				value := html.UnescapeString(strings.Trim(string(m[2]), `"'`))
+
+				if bytes.HasPrefix(bytes.ToLower(bytes.TrimSpace(m[1])), []byte("srcset")) {
+					candidates := strings.Split(value, ",")
+					for i, c := range candidates {
+						fields := strings.Fields(c)
+						if len(fields) > 0 {
+							fields[0] = resolve(fields[0])
+							candidates[i] = strings.Join(fields, " ")
+						}
+					}
+					value = strings.Join(candidates, ", ")
+				} else {
+					value = resolve(value)
+				}
+				out := append([]byte(nil), m[1]...)
+				return append(out, `"`+html.EscapeString(value)+`"`...)
+			})
+		}
+
+		r.Body = tagRe.ReplaceAllFunc(r.Body, func(m []byte) []byte {
+			// Scripts and styles are matched whole; only their start tag
+			// is rewritten.
+			end := bytes.IndexByte(m, '>') + 1
+			return append(rewrite(m[:end]), m[end:]...)
+		})
+		return nil
+	}
+}
+
+// Soft404 are the rules of DetectSoft404. A page matching any rule is a
+// soft 404.
+type Soft404 struct {
+	// Markers are texts of "not found" pages, like "Page not found",
+	// matched case-insensitively against the text of the page.
+	Markers []string
+	// Titles match the titles of "not found" pages.
+	Titles []*regexp.Regexp
+	// MinLength is the minimum length of the text of a page (without tags,
+	// scripts and styles). Shorter pages are empty app shells.
+	MinLength int
+}
+
+// DetectSoft404 returns a Transformer which changes the status of pages
+// served with 200 OK which are "not found" pages according to rules (soft
+// 404s) to 404 Not Found, so crawlers drop them from their index.
+func DetectSoft404(rules Soft404) Transformer {
+	markers := make([]string, len(rules.Markers))
+	for i, marker := range rules.Markers {
+		markers[i] = strings.ToLower(marker)
+	}
+
+	return func(r *RenderResult) error {
+		if r.Status != http.StatusOK || !isHTMLResult(r) {
+			return nil
+		}
+
+		if m := titleRe.FindSubmatch(r.Body); m != nil {
+			title := strings.TrimSpace(html.UnescapeString(string(m[1])))
+			for _, re := range rules.Titles {
+				if re.MatchString(title) {
+					r.Status = http.StatusNotFound
+					return nil
+				}
+			}
+		}
+
+		if len(markers) == 0 && rules.MinLength <= 0 {
+			return nil
+		}
+
+		text := pageText(r.Body)
+		if len(text) < rules.MinLength {
+			r.Status = http.StatusNotFound
+			return nil
+		}
+
+		text = strings.ToLower(text)
+		for _, marker := range markers {
+			if strings.Contains(text, marker) {
+				r.Status = http.StatusNotFound
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+// pageText returns the text of the body of an HTML page, with collapsed
+// whitespace.
+func pageText(page []byte) string {
+	if loc := bodyTagRe.FindIndex(page); loc != nil {
+		page = page[loc[0]:]
+	}
+	page = scriptRe.ReplaceAll(page, nil)
+	page = styleRe.ReplaceAll(page, nil)
+	page = anyTagRe.ReplaceAll(page, []byte(" "))
+	text := html.UnescapeString(string(page))
+	return strings.TrimSpace(whitespaceRe.ReplaceAllString(text, " "))
+}
+
+// isHTMLResult reports whether r is an HTML page.
+func isHTMLResult(r *RenderResult) bool {
+	ct := r.Header.Get("Content-Type")
+	return ct == "" || strings.Contains(strings.ToLower(ct), "html")
+}
+
+// tagAttrs returns the attributes of an HTML tag, with lower case names and
+// unescaped values.
+func tagAttrs(tag []byte) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range metaAttrRe.FindAllSubmatch(tag, -1) {
+		name := strings.ToLower(string(attr[1]))
+		if _, found := attrs[name]; !found {
+			attrs[name] = html.UnescapeString(string(attr[2]) + string(attr[3]) + string(attr[4]))
+		}
+	}
+	return attrs
+}
+
+// setAttr sets the value of the attribute name of an HTML tag.
+func setAttr(tag []byte, name, value string) []byte {
+	re := regexp.MustCompile(`(?is)(\s` + regexp.QuoteMeta(name) + `)\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+)`)
+	attr := []byte(name + `="` + html.EscapeString(value) + `"`)
+
+	if loc := re.FindIndex(tag); loc != nil {
+		return bytes.Join([][]byte{tag[:loc[0]], []byte(" "), attr, tag[loc[1]:]}, nil)
+	}
+
+	end := bytes.LastIndexByte(tag, '>')
+	if end > 0 && tag[end-1] == '/' {
+		end--
+	}
+	return bytes.Join([][]byte{tag[:end], []byte(" "), attr, tag[end:]}, nil)
+}
+
+// hasToken reports whether the space separated list s contains token.
+func hasToken(s, token string) bool {
+	for _, t := range strings.Fields(s) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertInHead inserts s at the start of the head of body. Bodies without a
+// head are not modified.
+func insertInHead(body []byte, s string) []byte {
+	if s == "" {
+		return body
+	}
+
+	loc := headTagRe.FindIndex(body)
+	if loc == nil {
+		return body
+	}
+	return bytes.Join([][]byte{body[:loc[1]], []byte(s), body[loc[1]:]}, nil)
+}
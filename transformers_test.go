@@ -0,0 +1,196 @@
+package prerender
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func transform(t *testing.T, tr Transformer, page, body string) *RenderResult {
+	t.Helper()
+
+	u, err := url.Parse(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RenderResult{
+		URL:    u,
+		Status: http.StatusOK,
+		Header: http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:   []byte(body),
+	}
+	if err := tr(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCanonicalTags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"missing tags",
+			`<html><head><title>x</title></head></html>`,
+			`<html><head><base href="https://example.com/a?b=1"><link rel="canonical" href="https://example.com/a?b=1"><title>x</title></head></html>`,
+		},
+		{
+			"renderer host",
+			`<head><base href="/"><link rel="canonical" href="http://localhost:3000/a"></head>`,
+			`<head><base href="https://example.com/"><link rel="canonical" href="https://example.com/a"></head>`,
+		},
+		{
+			"relative",
+			`<head><base href="/"><link href='b' rel="Canonical Alternate"/></head>`,
+			`<head><base href="https://example.com/"><link href="https://example.com/b" rel="Canonical Alternate"/></head>`,
+		},
+		{
+			"other host",
+			`<head><base href="https://cdn.example.com/"><link rel="canonical" href="https://www.example.com/a"></head>`,
+			`<head><base href="https://cdn.example.com/"><link rel="canonical" href="https://www.example.com/a"></head>`,
+		},
+		{"no head", `<p>x</p>`, `<p>x</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := transform(t, CanonicalTags(), "https://example.com/a?b=1#top", tt.body)
+			if string(r.Body) != tt.want {
+				t.Errorf("body = %s, want %s", r.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"whitespace", "<p>\n  a   b\t</p>\n", "<p> a b </p> "},
+		{"comments", "<p>a <!-- x --> b</p>", "<p>a b</p>"},
+		{"conditional comments", "<!--[if IE]>x<![endif]-->", "<!--[if IE]>x<![endif]-->"},
+		{"pre", "<pre>a\n  b</pre>  <textarea> x\n</textarea>", "<pre>a\n  b</pre> <textarea> x\n</textarea>"},
+		{"script and style", "<script>a  =\n1</script>\n<style>p  {}</style>", "<script>a  =\n1</script> <style>p  {}</style>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := transform(t, MinifyHTML(), "https://example.com/", tt.body)
+			if string(r.Body) != tt.want {
+				t.Errorf("body = %q, want %q", r.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripScripts(t *testing.T) {
+	const body = `<script src="/app.js"></script>` +
+		`<script type="application/ld+json">{}</script>` +
+		`<script src="https://maps.googleapis.com/maps.js"></script>` +
+		`<script>inline()</script>`
+
+	tests := []struct {
+		name        string
+		stripJSONLD bool
+		keep        []string
+		want        string
+	}{
+		{"default", false, nil, `<script type="application/ld+json">{}</script>`},
+		{"strip JSON-LD", true, nil, ``},
+		{"keep", false, []string{"maps.googleapis.com"}, `<script type="application/ld+json">{}</script><script src="https://maps.googleapis.com/maps.js"></script>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := transform(t, StripScripts(tt.stripJSONLD, tt.keep...), "https://example.com/", body)
+			if string(r.Body) != tt.want {
+				t.Errorf("body = %s, want %s", r.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsoluteLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"relative", `<a href="b">`, `<a href="https://example.com/dir/b">`},
+		{"root", `<img src='/i.png'>`, `<img src="https://example.com/i.png">`},
+		{"unquoted", `<form action=post>`, `<form action="https://example.com/dir/post">`},
+		{"absolute", `<a href="https://other.com/">`, `<a href="https://other.com/">`},
+		{"fragment", `<a href="#top">`, `<a href="#top">`},
+		{"srcset", `<img srcset="a.png 1x, /b.png 2x">`, `<img srcset="https://example.com/dir/a.png 1x, https://example.com/b.png 2x">`},
+		{"base", `<base href="/other/"><a href="b">`, `<base href="/other/"><a href="https://example.com/other/b">`},
+		{"script content", `<script src="s.js">var a = '<a href="x">'</script>`, `<script src="https://example.com/dir/s.js">var a = '<a href="x">'</script>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := transform(t, AbsoluteLinks(), "https://example.com/dir/page", tt.body)
+			if string(r.Body) != tt.want {
+				t.Errorf("body = %s, want %s", r.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectSoft404(t *testing.T) {
+	rules := Soft404{
+		Markers:   []string{"Page not found"},
+		Titles:    []*regexp.Regexp{regexp.MustCompile(`^404\b`)},
+		MinLength: 10,
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   int
+	}{
+		{"page", http.StatusOK, `<title>Home</title><body><p>Welcome to the shop</p></body>`, http.StatusOK},
+		{"title", http.StatusOK, `<title>404 - Missing</title><body><p>Welcome to the shop</p></body>`, http.StatusNotFound},
+		{"marker", http.StatusOK, `<body><h1>Oops!</h1><p>PAGE NOT FOUND</p></body>`, http.StatusNotFound},
+		{"empty shell", http.StatusOK, `<body><div id="app"></div><script>var long = "text text text"</script></body>`, http.StatusNotFound},
+		{"other status", http.StatusInternalServerError, `<title>404</title>`, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse("https://example.com/")
+			r := &RenderResult{URL: u, Status: tt.status, Header: http.Header{}, Body: []byte(tt.body)}
+			if err := DetectSoft404(rules)(r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != tt.want {
+				t.Errorf("status = %d, want %d", r.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformersSkipOtherTypes(t *testing.T) {
+	const body = `{"a":  "<a href=\"b\">"}`
+
+	for name, tr := range map[string]Transformer{
+		"CanonicalTags": CanonicalTags(),
+		"MinifyHTML":    MinifyHTML(),
+		"StripScripts":  StripScripts(true),
+		"AbsoluteLinks": AbsoluteLinks(),
+	} {
+		u, _ := url.Parse("https://example.com/")
+		r := &RenderResult{URL: u, Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(body)}
+		if err := tr(r); err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Body) != body {
+			t.Errorf("%s modified JSON: %s", name, r.Body)
+		}
+	}
+}
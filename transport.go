@@ -0,0 +1,178 @@
+package prerender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// configureTransport adds f to the options of the transport to the prerender
+// service. Handlers with transport options get their own copy of the shared
+// transport.
+func (h *Prerenderer) configureTransport(f func(t *http.Transport)) {
+	h.transportOptions = append(h.transportOptions, f)
+}
+
+// transport returns the transport to the prerender service.
+func (h *Prerenderer) transport() http.RoundTripper {
+	if h.customTransport != nil {
+		return h.customTransport
+	}
+
+	options := h.transportOptions[:len(h.transportOptions):len(h.transportOptions)]
+	if r := h.newResolver(); r != nil {
+		options = append(options, r.resolveTransport)
+	}
+	if path, ok := h.serviceSocket(); ok {
+		options = append(options, func(t *http.Transport) {
+			t.Proxy = nil
+			t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			}
+		})
+	}
+	if h.http2 != nil {
+		options = append(options, h.http2.configure)
+	}
+	if len(options) == 0 {
+		return sharedTransport
+	}
+
+	t := newTransport()
+	for _, f := range options {
+		f(t)
+	}
+	if h.http2 != nil && h.http2.maxStreams > 0 {
+		return streamLimiter{next: t, streams: make(chan struct{}, h.http2.maxStreams)}
+	}
+	return t
+}
+
+// serviceSocket returns the path of the unix socket of a service URL like
+// unix:///var/run/prerender.sock.
+func (h *Prerenderer) serviceSocket() (string, bool) {
+	u, err := url.Parse(h.prerenderServiceURL)
+	if err != nil || u.Scheme != "unix" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// serviceURL returns the HTTP URL of the prerender service.
+func (h *Prerenderer) serviceURL() string {
+	if _, ok := h.serviceSocket(); ok {
+		// The transport dials the socket whatever the host.
+		return "http://localhost/"
+	}
+	return h.prerenderServiceURL
+}
+
+// Transport sends the requests to the prerender service with rt. It can't be
+// combined with the options configuring the default transport, which uses
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func Transport(rt http.RoundTripper) Option {
+	return func(h *Prerenderer) {
+		h.customTransport = rt
+	}
+}
+
+// DialContext dials the connections to the prerender service with dial, for
+// example to reach it through a tunnel.
+func DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(h *Prerenderer) {
+		h.configureTransport(func(t *http.Transport) {
+			t.DialContext = dial
+		})
+	}
+}
+
+// ServiceProxy sends the requests to the prerender service through the proxy
+// at rawurl instead of the proxy of the environment. An empty rawurl
+// disables proxies.
+func ServiceProxy(rawurl string) Option {
+	var (
+		u   *url.URL
+		err error
+	)
+	if rawurl != "" {
+		u, err = url.Parse(rawurl)
+	}
+	return func(h *Prerenderer) {
+		if err != nil {
+			h.optionError(fmt.Errorf("invalid service proxy: %w", err))
+			return
+		}
+		h.configureTransport(func(t *http.Transport) {
+			t.Proxy = nil
+			if u != nil {
+				t.Proxy = http.ProxyURL(u)
+			}
+		})
+	}
+}
+
+// InsecureSkipVerify accepts any certificate of the prerender service. It is
+// meant for development with self-signed certificates only.
+func InsecureSkipVerify() Option {
+	return func(h *Prerenderer) {
+		h.insecureSkipVerify = true
+		h.configureTransport(func(t *http.Transport) {
+			t.TLSClientConfig.InsecureSkipVerify = true
+		})
+	}
+}
+
+// ClientCertificate presents cert to the prerender service, for services
+// requiring mutual TLS. It may be repeated.
+func ClientCertificate(cert tls.Certificate) Option {
+	return func(h *Prerenderer) {
+		h.configureTransport(func(t *http.Transport) {
+			t.TLSClientConfig.Certificates = append(t.TLSClientConfig.Certificates, cert)
+		})
+	}
+}
+
+// ClientCertificateFiles is like ClientCertificate with a PEM encoded
+// certificate and key read from files.
+func ClientCertificateFiles(certFile, keyFile string) Option {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	return func(h *Prerenderer) {
+		if err != nil {
+			h.optionError(fmt.Errorf("invalid client certificate: %w", err))
+			return
+		}
+		ClientCertificate(cert)(h)
+	}
+}
+
+// RootCAs verifies the certificate of the prerender service with pool
+// instead of the system roots, for services with a private CA.
+func RootCAs(pool *x509.CertPool) Option {
+	return func(h *Prerenderer) {
+		h.configureTransport(func(t *http.Transport) {
+			t.TLSClientConfig.RootCAs = pool
+		})
+	}
+}
+
+// RootCAFile is like RootCAs with the PEM encoded certificates of a file.
+func RootCAFile(path string) Option {
+	pool := x509.NewCertPool()
+	data, err := os.ReadFile(path)
+	if err == nil && !pool.AppendCertsFromPEM(data) {
+		err = fmt.Errorf("no certificates in %s", path)
+	}
+	return func(h *Prerenderer) {
+		if err != nil {
+			h.optionError(fmt.Errorf("invalid root CAs: %w", err))
+			return
+		}
+		RootCAs(pool)(h)
+	}
+}
@@ -0,0 +1,113 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+)
+
+// optionError records an error of an option, reported by New.
+func (h *Prerenderer) optionError(err error) {
+	if err != nil {
+		h.errs = append(h.errs, err)
+	}
+}
+
+// validate returns the errors of the options and of the resulting
+// configuration.
+func (h *Prerenderer) validate() error {
+	errs := append([]error(nil), h.errs...)
+	check := func(failed bool, format string, args ...interface{}) {
+		if failed {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if h.backend == nil {
+		u, err := url.Parse(h.prerenderServiceURL)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid service URL: %w", err))
+		case u.Scheme == "unix":
+			check(u.Host != "" || u.Path == "", "invalid service URL %q: want unix:///path/to/socket", h.prerenderServiceURL)
+			check(h.customTransport != nil, "Transport can't be combined with a unix socket service URL")
+		case u.Scheme != "http" && u.Scheme != "https":
+			errs = append(errs, fmt.Errorf("invalid service URL %q: scheme must be http, https or unix", h.prerenderServiceURL))
+		case u.Host == "":
+			errs = append(errs, fmt.Errorf("invalid service URL %q: missing host", h.prerenderServiceURL))
+		}
+	}
+
+	// Secret files are read on every render; check they can be read now.
+	for _, p := range []TokenProvider{h.tokenProvider, h.usernameProvider, h.passwordProvider} {
+		if f, ok := p.(*fileToken); ok {
+			_, err := f.Token(context.Background())
+			check(err != nil, "service credentials: %v", err)
+		}
+	}
+
+	check(h.customTransport != nil && len(h.transportOptions) > 0, "Transport can't be combined with TLS, proxy or dial options")
+	check(h.customTransport != nil && (h.serviceAddrs != nil || h.dnsTTL != 0), "Transport can't be combined with ResolveService or CacheDNS")
+	check(h.customTransport != nil && h.http2 != nil, "Transport can't be combined with HTTP2 or H2C")
+	if h.http2 != nil {
+		check(h.http2.maxStreams < 0, "negative HTTP/2 streams %d", h.http2.maxStreams)
+	}
+	check(h.dnsTTL < 0, "negative DNS cache TTL %s", h.dnsTTL)
+	check(h.client.Timeout < 0, "negative timeout %s", h.client.Timeout)
+	check(h.cacheTTL < 0, "negative cache TTL %s", h.cacheTTL)
+	for _, rule := range h.pathTTLs {
+		check(rule.ttl < 0, "negative cache TTL %s for %s", rule.ttl, rule.glob)
+	}
+	check(h.async != nil && h.cache == nil, "AsyncRender requires a Cache")
+	check(len(h.encodings) > 0 && !h.compress, "ContentEncoding requires Compress")
+	check(h.renderBudget < 0, "negative render budget %s", h.renderBudget)
+	check(h.robots != nil && h.robots.ttl <= 0, "RespectRobots requires a positive TTL")
+	check(h.minRenderInterval < 0, "negative minimum render interval %s", h.minRenderInterval)
+	check(h.maxRedirects < 0, "negative number of redirects %d", h.maxRedirects)
+	check(h.maxResponseBytes < 0, "negative maximum response size %d", h.maxResponseBytes)
+	check(h.maxHeaderCount < 0 || h.maxHeaderBytes < 0, "negative header limits %d, %d", h.maxHeaderCount, h.maxHeaderBytes)
+	check(h.truncateOversized && h.maxResponseBytes == 0, "TruncateOversized requires MaxResponseBytes")
+	check(len(h.methods) == 0, "no prerendered methods")
+
+	for _, pattern := range append(append([]string(nil), h.allowHeaders...), h.denyHeaders...) {
+		_, err := path.Match(pattern, "")
+		check(err != nil, "invalid header pattern %q: %v", pattern, err)
+	}
+
+	if h.sampleRate != nil {
+		check(*h.sampleRate < 0 || *h.sampleRate > 1, "sample rate %v out of range [0, 1]", *h.sampleRate)
+	}
+
+	check(h.overflow < OverflowApp || h.overflow > OverflowServiceUnavailable, "unknown rate limit overflow %d", h.overflow)
+	if s := h.renderSlots; s != nil {
+		check(s.maxQueue < 0, "negative render queue %d", s.maxQueue)
+		check(s.timeout < 0, "negative render queue timeout %s", s.timeout)
+	}
+	check(h.concurrencyOverflow < OverflowApp || h.concurrencyOverflow > OverflowServiceUnavailable, "unknown concurrency overflow %d", h.concurrencyOverflow)
+	check(h.minConfidence < 0 || h.minConfidence > 1, "classifier confidence %v out of range [0, 1]", h.minConfidence)
+
+	if f := h.faults; f != nil {
+		for _, rate := range []float64{f.LatencyRate, f.ErrorRate, f.MalformedRate} {
+			check(rate < 0 || rate > 1, "fault rate %v out of range [0, 1]", rate)
+		}
+	}
+
+	// Tenants repeat the errors of the shared options.
+	if len(errs) == 0 {
+		for host, t := range h.tenants {
+			if err := t.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("host %s: %w", host, err))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		if h.tenant {
+			return err
+		}
+		return fmt.Errorf("prerender: %w", err)
+	}
+	return nil
+}
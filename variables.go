@@ -27,6 +27,36 @@ var crawlerUserAgents = []string{
 	"twitterbot",
 }
 
+// searchBotMatcher matches the search engine crawlers added by
+// AllowSearchBots.
+var searchBotMatcher = substringMatcher([]string{
+	"googlebot",
+	"bingbot",
+	"yahoo! slurp",
+	"duckduckbot",
+	"yandex",
+})
+
+// performanceToolUserAgents are performance measurement tools (like
+// Lighthouse and PageSpeed Insights) which are handled according to the
+// PerformanceTools option.
+var performanceToolUserAgents = []string{
+	"chrome-lighthouse",
+	"google page speed insights",
+	"pagespeed",
+}
+
+// trackingParams are the query parameters removed by NormalizeURLs by
+// default.
+var trackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+}
+
 var extensionsToIgnore = []string{
 	".ai",
 	".avi",
@@ -34,7 +64,6 @@ var extensionsToIgnore = []string{
 	".dat",
 	".dmg",
 	".doc",
-	".doc",
 	".exe",
 	".flv",
 	".gif",
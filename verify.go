@@ -0,0 +1,179 @@
+package prerender
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// verifyTimeout bounds the DNS lookups of a bot verification.
+	verifyTimeout = 2 * time.Second
+	// verifyTTL and verifyFailureTTL are the times verification results
+	// are cached.
+	verifyTTL        = 24 * time.Hour
+	verifyFailureTTL = time.Hour
+	// maxVerifyEntries limits the number of cached verification results.
+	maxVerifyEntries = 10000
+)
+
+// verifiableBots maps the User-Agent substrings of crawlers which publish
+// their reverse DNS domains to those domains.
+var verifiableBots = []struct {
+	userAgent string
+	domains   []string
+}{
+	{"googlebot", []string{"googlebot.com", "google.com"}},
+	{"bingbot", []string{"search.msn.com"}},
+	{"yahoo! slurp", []string{"crawl.yahoo.net"}},
+	{"yandex", []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{"baiduspider", []string{"baidu.com", "baidu.jp"}},
+	{"applebot", []string{"applebot.apple.com"}},
+}
+
+// VerifyBots only prerenders for requests claiming to be a major search
+// engine crawler (Googlebot, Bingbot, Yahoo, Yandex, Baidu and Applebot)
+// when the client IP resolves to a host name of that crawler, which
+// resolves back to the IP. Scrapers spoofing these User-Agents are passed
+// through to the app. Results are cached per IP.
+//
+// Use TrustedProxies behind a proxy; X-Forwarded-For is ignored otherwise.
+func VerifyBots() Option {
+	return func(h *Prerenderer) {
+		h.verifier = &botVerifier{
+			resolver: net.DefaultResolver,
+			results:  make(map[string]verifyResult),
+		}
+	}
+}
+
+// dnsResolver are the lookups of a bot verification, implemented by
+// *net.Resolver.
+type dnsResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type botVerifier struct {
+	resolver dnsResolver
+
+	mtx     sync.Mutex
+	results map[string]verifyResult
+}
+
+type verifyResult struct {
+	ok      bool
+	expires time.Time
+}
+
+// verified reports whether req is not a spoofed verifiable crawler.
+func (h *Prerenderer) verified(req *http.Request) bool {
+	if ranges := h.rangesFor(req.UserAgent()); ranges != nil {
+		ip := h.clientIP(req)
+		return ip != nil && ranges.Contains(ip)
+	}
+
+	if h.verifier == nil {
+		return true
+	}
+
+	domains := claimedDomains(req.UserAgent())
+	if domains == nil {
+		return true
+	}
+
+	ip := h.clientIP(req)
+	if ip == nil {
+		return false
+	}
+	return h.verifier.verify(req.Context(), ip, domains)
+}
+
+// claimedDomains returns the reverse DNS domains of the crawler ua claims to
+// be, or nil.
+func claimedDomains(ua string) []string {
+	ua = strings.ToLower(ua)
+	for _, bot := range verifiableBots {
+		if strings.Contains(ua, bot.userAgent) {
+			return bot.domains
+		}
+	}
+	return nil
+}
+
+func (v *botVerifier) verify(ctx context.Context, ip net.IP, domains []string) bool {
+	key := ip.String() + "|" + strings.Join(domains, ",")
+	now := time.Now()
+
+	v.mtx.Lock()
+	r, found := v.results[key]
+	v.mtx.Unlock()
+	if found && now.Before(r.expires) {
+		return r.ok
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	ok, err := v.lookup(ctx, ip, domains)
+	if err != nil && ctx.Err() != nil {
+		// Don't cache timeouts and canceled requests.
+		return false
+	}
+
+	r = verifyResult{ok: ok, expires: now.Add(verifyTTL)}
+	if !ok {
+		r.expires = now.Add(verifyFailureTTL)
+	}
+
+	v.mtx.Lock()
+	if len(v.results) >= maxVerifyEntries {
+		for k := range v.results {
+			delete(v.results, k)
+			break
+		}
+	}
+	v.results[key] = r
+	v.mtx.Unlock()
+
+	return ok
+}
+
+// lookup resolves ip to a host name in one of domains, and the host name
+// back to ip.
+func (v *botVerifier) lookup(ctx context.Context, ip net.IP, domains []string) (bool, error) {
+	names, err := v.resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !inDomains(name, domains) {
+			continue
+		}
+
+		addrs, err := v.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func inDomains(name string, domains []string) bool {
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package prerender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeResolver resolves from maps, and fails for missing entries.
+type fakeResolver struct {
+	names map[string][]string
+	addrs map[string][]string
+	calls int
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.calls++
+	names, found := r.names[addr]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+	return names, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, found := r.addrs[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+	var ips []net.IPAddr
+	for _, addr := range addrs {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return ips, nil
+}
+
+func TestClaimedDomains(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want []string
+	}{
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", []string{"googlebot.com", "google.com"}},
+		{"Mozilla/5.0 (compatible; bingbot/2.0)", []string{"search.msn.com"}},
+		{"Mozilla/5.0 (compatible; YandexBot/3.0)", []string{"yandex.ru", "yandex.net", "yandex.com"}},
+		{"Twitterbot/1.0", nil},
+		{"Mozilla/5.0", nil},
+	}
+
+	for _, tt := range tests {
+		if got := claimedDomains(tt.ua); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("claimedDomains(%q) = %v, want %v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestInDomains(t *testing.T) {
+	domains := []string{"googlebot.com", "google.com"}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"crawl-66-249-66-1.googlebot.com", true},
+		{"rate-limited-proxy-66-249-90-77.google.com", true},
+		{"googlebot.com", true},
+		{"googlebot.com.evil.net", false},
+		{"evilgooglebot.com", false},
+		{"googleusercontent.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := inDomains(tt.name, domains); got != tt.want {
+			t.Errorf("inDomains(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBotVerifierLookup(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{
+			"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."},
+			"66.249.66.2": {"crawl-66-249-66-2.googlebot.com."},
+			"66.249.66.3": {"other.example.com.", "Crawl-66-249-66-3.GOOGLEBOT.com."},
+			"1.2.3.4":     {"googlebot.com.evil.net."},
+			"35.1.2.3":    {"1.2.3.35.bc.googleusercontent.com."},
+			"1.2.3.5":     {"crawl-66-249-66-1.googlebot.com."},
+		},
+		addrs: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com":   {"66.249.66.1"},
+			"crawl-66-249-66-2.googlebot.com":   {"66.249.66.9"},
+			"crawl-66-249-66-3.googlebot.com":   {"66.249.66.3"},
+			"googlebot.com.evil.net":            {"1.2.3.4"},
+			"1.2.3.35.bc.googleusercontent.com": {"35.1.2.3"},
+		},
+	}
+	domains := claimedDomains("Googlebot")
+
+	tests := []struct {
+		name    string
+		ip      string
+		want    bool
+		wantErr bool
+	}{
+		{"verified", "66.249.66.1", true, false},
+		{"forward mismatch", "66.249.66.2", false, false},
+		{"case and other names", "66.249.66.3", true, false},
+		{"other domain", "1.2.3.4", false, false},
+		{"cloud customer", "35.1.2.3", false, false},
+		{"spoofed reverse name", "1.2.3.5", false, false},
+		{"no reverse name", "1.2.3.6", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &botVerifier{resolver: resolver, results: make(map[string]verifyResult)}
+			got, err := v.lookup(context.Background(), net.ParseIP(tt.ip), domains)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("lookup(%s) = %v, %v, want %v, error %v", tt.ip, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBotVerifierCache(t *testing.T) {
+	resolver := &fakeResolver{
+		names: map[string][]string{"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."}},
+		addrs: map[string][]string{"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"}},
+	}
+	v := &botVerifier{resolver: resolver, results: make(map[string]verifyResult)}
+	domains := claimedDomains("Googlebot")
+
+	for _, ip := range []string{"66.249.66.1", "66.249.66.1", "1.2.3.4", "1.2.3.4"} {
+		v.verify(context.Background(), net.ParseIP(ip), domains)
+	}
+	if resolver.calls != 2 {
+		t.Errorf("%d lookups, want 2", resolver.calls)
+	}
+	suffix := "|" + strings.Join(domains, ",")
+	if !v.results["66.249.66.1"+suffix].ok || v.results["1.2.3.4"+suffix].ok {
+		t.Errorf("results = %v", v.results)
+	}
+}
@@ -0,0 +1,78 @@
+package warmer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/fd/prerender/internal/robots"
+)
+
+// allowed returns the urls allowed by the robots.txt of their site, and the
+// number of skipped ones.
+func (w *Warmer) allowed(ctx context.Context, urls []string) ([]string, int) {
+	var (
+		sites   = make(map[string]*robots.Robots)
+		kept    = make([]string, 0, len(urls))
+		skipped int
+	)
+
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			kept = append(kept, raw)
+			continue
+		}
+
+		site := u.Scheme + "://" + u.Host
+		r, found := sites[site]
+		if !found {
+			r = w.robots(ctx, site)
+			sites[site] = r
+		}
+
+		if r != nil {
+			if ok, pattern := r.Allowed(w.RobotsAgent, u.RequestURI()); !ok {
+				w.logf("warmer: %s: disallowed by robots.txt (%s)", raw, pattern)
+				skipped++
+				continue
+			}
+		}
+		kept = append(kept, raw)
+	}
+	return kept, skipped
+}
+
+// robots fetches the robots.txt of site. It returns nil, allowing all
+// pages, when robots.txt can't be fetched.
+func (w *Warmer) robots(ctx context.Context, site string) *robots.Robots {
+	r, err := w.fetchRobots(ctx, site+"/robots.txt")
+	if err != nil {
+		w.logf("warmer: %s, rendering all pages", err)
+	}
+	return r
+}
+
+func (w *Warmer) fetchRobots(ctx context.Context, u string) (*robots.Robots, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return robots.Parse(resp.Body), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// Like a missing robots.txt, which allows everything.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("fetching %s: unexpected status: %s", u, resp.Status)
+	}
+}
@@ -0,0 +1,278 @@
+package warmer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// anyDom and anyDow are set when the day of the month or of the week
+	// is "*", so the other one alone selects the days.
+	anyDom, anyDow bool
+}
+
+// fieldRanges are the ranges of the fields of cron expressions.
+var fieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a standard cron expression of five fields (minute,
+// hour, day of the month, month and day of the week), like "*/15 * * * *"
+// or "0 3 * * 1-5", or a macro like @daily. Fields are lists of values,
+// ranges and steps; days of the week are 0 (Sunday) to 6, and 7 is also
+// Sunday.
+func ParseSchedule(expr string) (*Schedule, error) {
+	if m, found := macros[strings.TrimSpace(expr)]; found {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("warmer: invalid schedule %q: expected 5 fields", expr)
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		first, last := fieldRanges[i][0], fieldRanges[i][1]
+		if i == 4 {
+			last = 7
+		}
+		b, err := parseField(field, first, last)
+		if err != nil {
+			return nil, fmt.Errorf("warmer: invalid schedule %q: %s", expr, err)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}, nil
+}
+
+// parseField returns the bits of the values of a field between first and
+// last.
+func parseField(field string, first, last int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		lo, hi := first, last
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loText)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiText)
+				}
+			} else if hasStep {
+				hi = last
+			}
+		}
+		if lo < first || hi > last || lo > hi {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, first, last)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t matching the schedule, or the zero
+// time if there is none within five years (like for February 30).
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches. Like cron, when both the
+// day of the month and of the week are restricted either may match.
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	switch {
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// A Lister lists the cached pages, for jobs re-rendering the entire cache.
+// *prerender.Prerenderer implements Lister.
+type Lister interface {
+	CachedURLs(ctx context.Context) ([]string, error)
+}
+
+// A Job is a set of pages re-rendered on a schedule.
+type Job struct {
+	// Name identifies the job in the log.
+	Name string
+	// URLs are the pages to render, along with the pages listed in
+	// Sitemaps, which are fetched for every run. Without either, all cached
+	// pages are rendered again, which requires the Renderer of the Warmer
+	// to be a Lister.
+	URLs     []string
+	Sitemaps []string
+}
+
+// A Scheduler renders jobs on cron schedules, like every 15 minutes for the
+// home page and nightly for the docs. Jobs run one at a time with the
+// concurrency and rate of the Warmer, so schedules don't pile up renders; a
+// job which is due while another runs starts when it is done.
+type Scheduler struct {
+	Warmer *Warmer
+	// Jitter delays every run by a random duration up to Jitter, so
+	// instances sharing a schedule don't render at the same time.
+	Jitter time.Duration
+
+	jobs []*scheduledJob
+}
+
+type scheduledJob struct {
+	Job
+	schedule *Schedule
+	next     time.Time
+}
+
+// NewScheduler returns a Scheduler rendering with w.
+func NewScheduler(w *Warmer) *Scheduler {
+	return &Scheduler{Warmer: w}
+}
+
+// Add schedules job with the cron expression spec, see ParseSchedule.
+func (s *Scheduler) Add(spec string, job Job) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	if job.Name == "" {
+		job.Name = spec
+	}
+	s.jobs = append(s.jobs, &scheduledJob{Job: job, schedule: schedule})
+	return nil
+}
+
+// Run runs the jobs until ctx is done, and returns its error.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for _, job := range s.jobs {
+		job.next = s.next(job, time.Now())
+	}
+
+	for {
+		var due *scheduledJob
+		for _, job := range s.jobs {
+			if !job.next.IsZero() && (due == nil || job.next.Before(due.next)) {
+				due = job
+			}
+		}
+		if due == nil {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		timer := time.NewTimer(time.Until(due.next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		s.run(ctx, due)
+		due.next = s.next(due, time.Now())
+	}
+}
+
+// next returns the time of the next run of job after t, with jitter.
+func (s *Scheduler) next(job *scheduledJob, t time.Time) time.Time {
+	next := job.schedule.Next(t)
+	if next.IsZero() || s.Jitter <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+}
+
+// run renders the pages of job.
+func (s *Scheduler) run(ctx context.Context, job *scheduledJob) {
+	w := s.Warmer
+
+	urls := job.URLs
+	if len(job.Sitemaps) > 0 {
+		listed, err := w.URLs(ctx, job.Sitemaps...)
+		if err != nil {
+			w.logf("warmer: job %s: %s", job.Name, err)
+			return
+		}
+		urls = append(urls[:len(urls):len(urls)], listed...)
+	}
+	if len(job.URLs) == 0 && len(job.Sitemaps) == 0 {
+		lister, ok := w.Renderer.(Lister)
+		if !ok {
+			w.logf("warmer: job %s: the renderer can't list the cached pages", job.Name)
+			return
+		}
+		var err error
+		if urls, err = lister.CachedURLs(ctx); err != nil {
+			w.logf("warmer: job %s: %s", job.Name, err)
+			return
+		}
+	}
+
+	start := time.Now()
+	result := w.Render(ctx, urls)
+	w.logf("warmer: job %s: rendered %d, failed %d in %s", job.Name, result.Rendered, result.Failed, time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,80 @@
+package warmer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 * * * *", false},
+		{"0 3 * * 1-5", false},
+		{"0,30 8-18/2 1 1,7 *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{" @hourly ", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+		{"@weekdays", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	start := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", start, time.Date(2024, 1, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", start, time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 3 * * *", start, time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", start, time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", start, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", start, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", start, time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", start, time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", start, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		// Either the day of the month or of the week matches.
+		{"0 0 15 * 5", start, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", start, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package warmer pre-renders the pages listed in sitemaps into the cache of
+// a prerender handler, so crawlers don't hit a cold cache after a deploy.
+package warmer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSitemapBytes limits the size of a (decompressed) sitemap, which is
+// 50MB per the sitemaps protocol.
+const maxSitemapBytes = 50 << 20
+
+// maxDepth limits the nesting of sitemap indexes.
+const maxDepth = 4
+
+// A Renderer renders a page into a cache. *prerender.Prerenderer implements
+// Renderer.
+type Renderer interface {
+	Recache(ctx context.Context, url string) error
+}
+
+// A Warmer pre-renders the pages listed in sitemaps.
+type Warmer struct {
+	Renderer Renderer
+	// Client fetches the sitemaps. It defaults to http.DefaultClient.
+	Client *http.Client
+	// Concurrency is the number of concurrent renders. It defaults to 1.
+	Concurrency int
+	// Rate limits the number of renders per second. Zero means no limit.
+	Rate float64
+	// Logger logs failed renders when it is not nil.
+	Logger *log.Logger
+	// Robots skips the pages the robots.txt of their site disallows for
+	// RobotsAgent, so no renders are wasted on pages crawlers never
+	// request. Pages are rendered when robots.txt can't be fetched.
+	Robots bool
+	// RobotsAgent is the crawler whose robots.txt rules apply, like
+	// Googlebot. Empty applies the rules for all crawlers (*).
+	RobotsAgent string
+}
+
+// Result summarizes a warming run.
+type Result struct {
+	Rendered int
+	Failed   int
+	// Skipped counts the pages disallowed by robots.txt.
+	Skipped int
+}
+
+// New returns a Warmer for r.
+func New(r Renderer) *Warmer {
+	return &Warmer{Renderer: r}
+}
+
+// Warm renders all pages listed in the sitemaps (and the sitemaps they
+// index). It returns an error when a sitemap can't be fetched; failed renders
+// are counted in the result.
+func (w *Warmer) Warm(ctx context.Context, sitemaps ...string) (Result, error) {
+	urls, err := w.URLs(ctx, sitemaps...)
+	if err != nil {
+		return Result{}, err
+	}
+	return w.Render(ctx, urls), nil
+}
+
+// Render renders the pages at urls.
+func (w *Warmer) Render(ctx context.Context, urls []string) Result {
+	var skipped int
+	if w.Robots {
+		urls, skipped = w.allowed(ctx, urls)
+	}
+
+	var (
+		result   Result
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+		queue    = make(chan string)
+		workers  = w.Concurrency
+		throttle <-chan time.Time
+	)
+
+	if workers < 1 {
+		workers = 1
+	}
+	if w.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / w.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				err := w.Renderer.Recache(ctx, u)
+
+				mtx.Lock()
+				if err != nil {
+					result.Failed++
+					w.logf("warmer: %s: %s", u, err)
+				} else {
+					result.Rendered++
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, u := range urls {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		select {
+		case queue <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	result.Skipped = skipped
+	return result
+}
+
+// URLs returns the page URLs listed in the sitemaps, following sitemap
+// indexes. Gzipped sitemaps are supported.
+func (w *Warmer) URLs(ctx context.Context, sitemaps ...string) ([]string, error) {
+	var (
+		urls []string
+		seen = make(map[string]bool)
+	)
+
+	var walk func(sitemap string, depth int) error
+	walk = func(sitemap string, depth int) error {
+		if seen[sitemap] {
+			return nil
+		}
+		seen[sitemap] = true
+
+		if depth > maxDepth {
+			return fmt.Errorf("warmer: sitemap indexes nested too deep at %s", sitemap)
+		}
+
+		doc, err := w.fetch(ctx, sitemap)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range doc.URLs {
+			urls = append(urls, u.Loc)
+		}
+		for _, s := range doc.Sitemaps {
+			if err := walk(s.Loc, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, sitemap := range sitemaps {
+		if err := walk(sitemap, 0); err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
+
+// document is either a urlset or a sitemapindex.
+type document struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+func (w *Warmer) fetch(ctx context.Context, sitemap string) (*document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sitemap, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("warmer: fetching %s: unexpected status: %s", sitemap, resp.Status)
+	}
+
+	var r io.Reader = bufio.NewReader(resp.Body)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var doc document
+	if err := xml.NewDecoder(io.LimitReader(r, maxSitemapBytes)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("warmer: parsing %s: %s", sitemap, err)
+	}
+	for i := range doc.URLs {
+		doc.URLs[i].Loc = strings.TrimSpace(doc.URLs[i].Loc)
+	}
+	for i := range doc.Sitemaps {
+		doc.Sitemaps[i].Loc = strings.TrimSpace(doc.Sitemaps[i].Loc)
+	}
+	return &doc, nil
+}
+
+func (w *Warmer) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+func (w *Warmer) logf(format string, args ...interface{}) {
+	if w.Logger != nil {
+		w.Logger.Printf(format, args...)
+	}
+}
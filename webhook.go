@@ -0,0 +1,210 @@
+package prerender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxWebhookBytes limits the size of webhook payloads.
+const maxWebhookBytes = 1 << 20
+
+// maxWebhookQueue limits the number of URLs waiting to be rendered by a
+// PublishWebhook.
+const maxWebhookQueue = 1000
+
+// errNoCache is returned by Recache without a Cache, where renders would be
+// thrown away.
+var errNoCache = errors.New("prerender: no cache configured")
+
+// PublishWebhook returns an http.Handler which accepts publish webhooks from
+// a CMS and re-renders the published URLs into the cache. Requests must be
+// authenticated with secret, either as a bearer token or as an HMAC-SHA256
+// signature of the body in the X-Prerender-Signature header.
+//
+// urlPath selects the URLs in the JSON payload. It is a dot separated path
+// where * selects all elements of an array, like "entry.url" or
+// "entries.*.permalink". The selected values must be absolute URLs or
+// arrays of them.
+//
+// The URLs are rendered one at a time in the background with Recache,
+// until ctx is done; webhooks are refused with 503 Service Unavailable when
+// too many URLs are waiting, when there is no Cache to render into, or once
+// ctx is done.
+func (h *Prerenderer) PublishWebhook(ctx context.Context, secret, urlPath string) http.Handler {
+	var (
+		queue = make(chan string, maxWebhookQueue)
+		once  sync.Once
+	)
+	work := func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rawurl := <-queue:
+				if err := h.Recache(ctx, rawurl); err != nil {
+					h.logf("prerender webhook error: %s: %s", rawurl, err)
+				}
+			}
+		}
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			rw.Header().Set("Allow", "POST")
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBytes))
+		if err != nil {
+			http.Error(rw, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		if !authorized(req, body, secret) {
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var payload interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(rw, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		if !h.hasCache() {
+			http.Error(rw, "No cache configured", http.StatusServiceUnavailable)
+			return
+		}
+		if ctx.Err() != nil {
+			http.Error(rw, "Shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		urls := selectStrings(payload, strings.Split(urlPath, "."))
+		if len(urls) > cap(queue)-len(queue) {
+			rw.Header().Set("Retry-After", "60")
+			http.Error(rw, "Too many pending renders", http.StatusServiceUnavailable)
+			return
+		}
+
+		once.Do(func() { go work() })
+		for _, rawurl := range urls {
+			select {
+			case queue <- rawurl:
+			default:
+				h.logf("prerender webhook: queue full, dropping %s", rawurl)
+			}
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(map[string]interface{}{"urls": urls})
+	})
+}
+
+// selectStrings returns the strings at path in a decoded JSON value.
+func selectStrings(v interface{}, path []string) []string {
+	if len(path) == 0 || (len(path) == 1 && path[0] == "") {
+		switch v := v.(type) {
+		case string:
+			return []string{v}
+		case []interface{}:
+			var values []string
+			for _, elem := range v {
+				if s, ok := elem.(string); ok {
+					values = append(values, s)
+				}
+			}
+			return values
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return selectStrings(v[path[0]], path[1:])
+	case []interface{}:
+		if path[0] != "*" {
+			return nil
+		}
+		var values []string
+		for _, elem := range v {
+			values = append(values, selectStrings(elem, path[1:])...)
+		}
+		return values
+	}
+	return nil
+}
+
+// hasCache reports whether h or one of its hosts has a Cache.
+func (h *Prerenderer) hasCache() bool {
+	if h.cache != nil {
+		return true
+	}
+	for _, t := range h.tenants {
+		if t.cache != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// recacheUserAgent is the User-Agent sent to the prerender service for
+// renders which were not triggered by a crawler.
+const recacheUserAgent = "Mozilla/5.0 (compatible; prerender)"
+
+// Recache renders the absolute URL rawurl and stores it in the cache,
+// replacing any cached snapshot. It fails without a Cache, and like the
+// renders for crawlers, for hosts not in AllowedHosts and beyond the limits
+// of MaxConcurrentRenders and MaxRendersPerSecond.
+func (h *Prerenderer) Recache(ctx context.Context, rawurl string) error {
+	u, err := h.targetURL(rawurl)
+	if err != nil {
+		return err
+	}
+	if t := h.tenants[strings.ToLower(u.Hostname())]; t != nil {
+		return t.Recache(ctx, rawurl)
+	}
+	if h.cache == nil {
+		return errNoCache
+	}
+	if !h.allowsHost(u.Hostname()) {
+		return fmt.Errorf("prerender: host %q is not allowed", u.Hostname())
+	}
+
+	if h.renderSlots != nil {
+		if !h.renderSlots.acquire(ctx) {
+			h.load.saturate()
+			return errSaturated
+		}
+		defer h.releaseSlot()
+	}
+	if h.renderLimiter != nil && !h.renderLimiter.allow() {
+		h.load.throttle()
+		return errThrottled
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", recacheUserAgent)
+
+	snap, err := h.render(req, u)
+	if err != nil {
+		return err
+	}
+	if snap.Status >= 500 {
+		return fmt.Errorf("render failed with status %d", snap.Status)
+	}
+
+	h.store(ctx, h.cacheKey(nil, u), snap)
+	return nil
+}
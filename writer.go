@@ -0,0 +1,201 @@
+package prerender
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseWriter records the status and size of a response. Use wrap to get
+// an http.ResponseWriter which preserves the optional interfaces
+// (http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom) of the
+// underlying writer, so outer middlewares relying on them keep working.
+// Unwrap gives http.ResponseController access to the underlying writer.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type flusher struct{ *responseWriter }
+
+func (w flusher) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct{ *responseWriter }
+
+func (w hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type pusher struct{ *responseWriter }
+
+func (w pusher) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type readerFrom struct{ *responseWriter }
+
+func (w readerFrom) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	w.written += n
+	return n, err
+}
+
+// wrap returns an http.ResponseWriter which implements exactly the optional
+// interfaces implemented by the underlying writer.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	const (
+		isFlusher = 1 << iota
+		isHijacker
+		isPusher
+		isReaderFrom
+	)
+
+	var kind int
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		kind |= isFlusher
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		kind |= isHijacker
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		kind |= isPusher
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		kind |= isReaderFrom
+	}
+
+	var (
+		f = flusher{w}
+		h = hijacker{w}
+		p = pusher{w}
+		r = readerFrom{w}
+	)
+
+	switch kind {
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{w, f}
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{w, h}
+	case isFlusher | isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case isPusher:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{w, p}
+	case isFlusher | isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{w, f, p}
+	case isHijacker | isPusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, h, p}
+	case isFlusher | isHijacker | isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, f, h, p}
+	case isReaderFrom:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+		}{w, r}
+	case isFlusher | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, f, r}
+	case isHijacker | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, h, r}
+	case isFlusher | isHijacker | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, f, h, r}
+	case isPusher | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Pusher
+			io.ReaderFrom
+		}{w, p, r}
+	case isFlusher | isPusher | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{w, f, p, r}
+	case isHijacker | isPusher | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, h, p, r}
+	case isFlusher | isHijacker | isPusher | isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{w, f, h, p, r}
+	default:
+		return w
+	}
+}